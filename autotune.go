@@ -0,0 +1,135 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+
+	"github.com/zentures/cityhash"
+)
+
+// Hashers that Autotune chooses from, by the name recorded in a Config.
+var autotuneHashers = map[string]func() hash.Hash{
+	"cityhash64": func() hash.Hash { return cityhash.New64() },
+	"fnv64a":     func() hash.Hash { return fnv.New64a() },
+}
+
+// Candidate error rates, as multiples of the target false-positive rate, and
+// fill ratios searched by Autotune.
+var (
+	autotuneRates      = []float64{2, 1.5, 1, .75, .5, .25}
+	autotuneFillRatios = []float64{.5, .4, .6}
+)
+
+// Config is a filter configuration chosen by Autotune.  It can be persisted,
+// e.g. as JSON, and applied with New(c.N, c.Options()...).
+type Config struct {
+	// N is the number of items the filter is sized for.
+	N uint `json:"n"`
+
+	// ErrorRate and FillRatio are the e and p passed to WithErrorRate and
+	// WithFillRatio.
+	ErrorRate float64 `json:"error_rate"`
+	FillRatio float64 `json:"fill_ratio"`
+
+	// Hash names the hasher passed to WithHash: "cityhash64" or "fnv64a".
+	Hash string `json:"hash"`
+}
+
+// Options returns the options applying the configuration.  An unknown Hash
+// selects the default hasher.
+func (c Config) Options() []Option {
+	var h hash.Hash
+	if fn, ok := autotuneHashers[c.Hash]; ok {
+		h = fn()
+	}
+
+	return []Option{WithHash(h), WithErrorRate(c.ErrorRate), WithFillRatio(c.FillRatio)}
+}
+
+// Autotune searches for the smallest configuration of a filter holding the
+// items of sample whose false-positive rate, measured on sample, is at most
+// targetFPR and whose SizeInBytes is at most maxBytes.
+//
+// The search tries a fixed set of error rates around targetFPR, fill ratios
+// and hashers, in increasing order of size, stopping at the first that meets
+// the target.  Each is measured by adding half of sample to a filter sized
+// for that half and checking the other half, so sample must hold distinct
+// items, and enough of them for the target to be measurable: at least
+// 10/targetFPR.
+func Autotune(sample [][]byte, targetFPR float64, maxBytes uint) (Config, error) {
+	if targetFPR <= 0 || targetFPR >= 1 {
+		return Config{}, errors.New("bloom: target false-positive rate out of range (0, 1)")
+	}
+
+	if len(sample) < 2 {
+		return Config{}, errors.New("bloom: sample too small")
+	}
+
+	var candidates []Config
+	for _, r := range autotuneRates {
+		for _, p := range autotuneFillRatios {
+			for name := range autotuneHashers {
+				candidates = append(candidates, Config{
+					N:         uint(len(sample)),
+					ErrorRate: r * targetFPR,
+					FillRatio: p,
+					Hash:      name,
+				})
+			}
+		}
+	}
+
+	size := func(c Config) uint {
+		k := K(c.ErrorRate)
+		return footprint(k, S(M(c.N, c.FillRatio, c.ErrorRate), k))
+	}
+
+	// Hashers of equal size are tried in name order, so that the search is
+	// deterministic.
+	sort.Slice(candidates, func(i, j int) bool {
+		si, sj := size(candidates[i]), size(candidates[j])
+		return si < sj || si == sj && candidates[i].Hash < candidates[j].Hash
+	})
+
+	members, probes := sample[:len(sample)/2], sample[len(sample)/2:]
+	for _, c := range candidates {
+		if size(c) > maxBytes {
+			break
+		}
+
+		bf := New(uint(len(members)), c.Options()...)
+		for _, item := range members {
+			bf.Add(item)
+		}
+
+		fp := 0
+		for _, item := range probes {
+			if bf.Check(item) {
+				fp++
+			}
+		}
+
+		if float64(fp) <= targetFPR*float64(len(probes)) {
+			return c, nil
+		}
+	}
+
+	return Config{}, fmt.Errorf("bloom: no configuration meets a false-positive rate of %g in %d bytes", targetFPR, maxBytes)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestAutotune(t *testing.T) {
+	t.Parallel()
+
+	sample := make([][]byte, 50000)
+	for i := range sample {
+		sample[i] = []byte(web2[i])
+	}
+
+	const target = .01
+
+	c, err := Autotune(sample, target, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bf := New(c.N, c.Options()...)
+	for _, item := range sample {
+		bf.Add(item)
+	}
+
+	if size := bf.SizeInBytes(); size > 1<<20 {
+		t.Errorf("configuration %+v needs %d bytes", c, size)
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / float64(len(web2a)); rate > 1.5*target {
+		t.Errorf("configuration %+v has a false-positive rate of %g on the holdout", c, rate)
+	}
+
+	if _, err := Autotune(sample, target, 1000); err == nil {
+		t.Error("expected no configuration to fit in 1000 bytes")
+	}
+}
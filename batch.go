@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// batchBuckets is the number of ranges of a partition into which AddAll
+// groups bit locations.
+const batchBuckets = 1024
+
+// AddAll adds each of items, as calling Add for each would.  Rather than
+// setting the bits of one item at a time, it computes the bit locations of
+// the whole batch, then sets them one partition at a time, grouped into
+// ascending ranges of the partition, so that memory is walked roughly
+// sequentially and adjacent locations in the same word are ORed in with a
+// single write.  This pays off for large batches on filters too large to stay
+// in cache.
+func (f *Filter) AddAll(items [][]byte) {
+	if len(items) == 0 {
+		return
+	}
+
+	f.allocate()
+
+	// locs holds the locations of partition i in locs[i*n:(i+1)*n].
+	n := uint(len(items))
+	locs := make([]uint, n*f.k)
+	for j, item := range items {
+		if f.wal != nil {
+			f.log(item)
+		}
+
+		f.bits(item)
+		for i, v := range f.bs[:f.k] {
+			locs[uint(i)*n+uint(j)] = v
+		}
+	}
+
+	// Group each partition's locations by range with a counting sort, so
+	// that they are set in ascending order of range.
+	shift := uint(bits.Len(f.s))
+	if shift > 10 {
+		shift -= 10
+	} else {
+		shift = 0
+	}
+
+	var (
+		ones   uint64
+		sorted = make([]uint, n)
+		counts [batchBuckets + 1]uint
+	)
+	for i, b := range f.b {
+		p := locs[uint(i)*n : uint(i+1)*n]
+
+		counts = [batchBuckets + 1]uint{}
+		for _, v := range p {
+			counts[v>>shift+1]++
+		}
+		for r := 1; r < len(counts); r++ {
+			counts[r] += counts[r-1]
+		}
+		for _, v := range p {
+			sorted[counts[v>>shift]] = v
+			counts[v>>shift]++
+		}
+
+		set := b.Bytes()
+		for j := 0; j < len(sorted); {
+			w := sorted[j] / 64
+
+			var mask uint64
+			for ; j < len(sorted) && sorted[j]/64 == w; j++ {
+				mask |= 1 << (sorted[j] % 64)
+			}
+
+			ones += uint64(bits.OnesCount64(mask &^ set[w]))
+			set[w] |= mask
+		}
+	}
+
+	if f.fillCounter {
+		atomic.AddUint64(&f.ones, ones)
+	}
+
+	before := f.c
+	f.c += n
+	if f.c/fprSampleInterval != before/fprSampleInterval {
+		f.sampleFPR()
+	}
+}
+
+// CheckAll checks each of items, as calling Check for each would, and returns
+// the results in the same order.  It reuses the filter's hasher and scratch
+// space across the batch, so unlike Check it must not be called concurrently.
+func (f *Filter) CheckAll(items [][]byte) []bool {
+	found := make([]bool, len(items))
+	if f.IsEmpty() {
+		return found
+	}
+
+	for i, item := range items {
+		f.bits(item)
+		found[i] = f.test()
+	}
+	return found
+}
+
+// NewItems adds the items not already present and returns them, in order.
+// Items present in the filter, or repeated earlier in the batch, are left out,
+// so that feeding a stream through NewItems yields only first-seen items.
+// Like Check, it may mistake a new item for a present one, with the filter's
+// false-positive rate.  Each item is hashed once for both the lookup and the
+// insertion.
+func (f *Filter) NewItems(items [][]byte) [][]byte {
+	var fresh [][]byte
+	for _, item := range items {
+		f.bits(item)
+		if f.test() {
+			continue
+		}
+
+		if f.wal != nil {
+			f.log(item)
+		}
+		f.set()
+		fresh = append(fresh, item)
+	}
+	return fresh
+}
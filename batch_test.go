@@ -0,0 +1,192 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestAddAll(t *testing.T) {
+	t.Parallel()
+
+	items := make([][]byte, len(web2))
+	for i, w := range web2 {
+		items[i] = []byte(w)
+	}
+
+	bf := New(uint(len(web2)), WithFillCounter())
+	bf.AddAll(items)
+
+	want := New(uint(len(web2)))
+	for _, item := range items {
+		want.Add(item)
+	}
+
+	if bf.Count() != want.Count() {
+		t.Fatalf("expected count %d, got %d", want.Count(), bf.Count())
+	}
+
+	for i := range bf.b {
+		if !bf.b[i].Equal(want.b[i]) {
+			t.Fatalf("partition %d differs from adding items one at a time", i)
+		}
+	}
+
+	if bf.BitsSet() != want.count() {
+		t.Errorf("expected the fill counter to hold %d bits, got %d", want.count(), bf.BitsSet())
+	}
+
+	testBloomFilter(t, bf)
+}
+
+func BenchmarkAddAll(b *testing.B) {
+	benchmarkBatch(b, (*Filter).AddAll)
+}
+
+func BenchmarkAddEach(b *testing.B) {
+	benchmarkBatch(b, func(f *Filter, items [][]byte) {
+		for _, item := range items {
+			f.Add(item)
+		}
+	})
+}
+
+// benchmarkBatch adds web2 in batches of 100000 items to a filter sized for
+// ten million, too large to stay in cache.
+func benchmarkBatch(b *testing.B, add func(*Filter, [][]byte)) {
+	items := make([][]byte, 100000)
+	for i := range items {
+		items[i] = []byte(web2[i])
+	}
+
+	bf := New(10000000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		add(bf, items)
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	items := make([][]byte, len(web2a))
+	for i, w := range web2a {
+		items[i] = []byte(w)
+	}
+
+	found := bf.CheckAll(items)
+	if len(found) != len(items) {
+		t.Fatalf("expected %d results, got %d", len(items), len(found))
+	}
+
+	for i, item := range items {
+		if found[i] != bf.Check(item) {
+			t.Fatalf("expected CheckAll to agree with Check on %q", item)
+		}
+	}
+
+	if found := New(10).CheckAll(items[:3]); len(found) != 3 || found[0] || found[1] || found[2] {
+		t.Errorf("expected an empty filter to report nothing found, got %v", found)
+	}
+}
+
+func BenchmarkCheckAll(b *testing.B) {
+	benchmarkCheckBatch(b, (*Filter).CheckAll)
+}
+
+func BenchmarkCheckEach(b *testing.B) {
+	benchmarkCheckBatch(b, func(f *Filter, items [][]byte) []bool {
+		found := make([]bool, len(items))
+		for i, item := range items {
+			found[i] = f.Check(item)
+		}
+		return found
+	})
+}
+
+// benchmarkCheckBatch checks batches of 100000 items of web2 against a filter
+// holding all of web2.
+func benchmarkCheckBatch(b *testing.B, check func(*Filter, [][]byte) []bool) {
+	items := make([][]byte, 100000)
+	for i := range items {
+		items[i] = []byte(web2[i])
+	}
+
+	bf := New(uint(len(web2)))
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		check(bf, items)
+	}
+}
+
+func TestNewItems(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	// The batch holds 500 pre-existing items, then 1000 new ones each
+	// repeated once.
+	var batch [][]byte
+	for _, w := range web2[500:1000] {
+		batch = append(batch, []byte(w))
+	}
+	for _, w := range web2[1000:2000] {
+		batch = append(batch, []byte(w), []byte(w))
+	}
+
+	fresh := bf.NewItems(batch)
+	if len(fresh) > 1000 || len(fresh) < 990 {
+		t.Fatalf("expected about 1000 new items, got %d", len(fresh))
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range fresh {
+		if seen[string(item)] {
+			t.Fatalf("%q returned twice", item)
+		}
+		seen[string(item)] = true
+
+		if !bf.Check(item) {
+			t.Fatalf("expected %q to have been added", item)
+		}
+	}
+
+	for _, w := range web2[:1000] {
+		if seen[w] {
+			t.Fatalf("pre-existing item %q returned", w)
+		}
+	}
+
+	if bf.Count() != 1000+uint(len(fresh)) {
+		t.Errorf("expected count %d, got %d", 1000+len(fresh), bf.Count())
+	}
+
+	if again := bf.NewItems(batch); len(again) != 0 {
+		t.Errorf("expected no new items on a repeated batch, got %d", len(again))
+	}
+}
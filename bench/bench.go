@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bench provides a reusable benchmark harness for comparing the
+// filter layouts offered by package bloom on caller-supplied data.
+package bench
+
+import (
+	"testing"
+
+	"github.com/bits-and-blooms/bitset"
+	"github.com/blocknative/bloom"
+)
+
+// Filter is the subset of the filter API exercised by the harness.
+type Filter interface {
+	Add([]byte)
+	Check([]byte) bool
+}
+
+// Layout builds a filter sized to hold n items.
+type Layout struct {
+	Name string
+	New  func(n uint, opt ...bloom.Option) Filter
+}
+
+// Layouts lists the filter layouts compared by BenchmarkLayouts: those of
+// package bloom, and a standard single-array filter for reference.
+var Layouts = []Layout{
+	{
+		Name: "partitioned",
+		New: func(n uint, opt ...bloom.Option) Filter {
+			return bloom.New(n, opt...)
+		},
+	},
+	{
+		Name: "standard",
+		New: func(n uint, opt ...bloom.Option) Filter {
+			return newStandard(n, opt...)
+		},
+	},
+	{
+		Name: "blocked",
+		New: func(n uint, opt ...bloom.Option) Filter {
+			return bloom.NewBlocked(n, opt...)
+		},
+	},
+	{
+		Name: "scalable",
+		New: func(n uint, opt ...bloom.Option) Filter {
+			return bloom.NewScalable(n, opt...)
+		},
+	},
+}
+
+// standard is the reference layout: a classic bloom filter whose k bits per
+// item all lie in a single array of m bits, rather than one bit in each of k
+// partitions.  It has the same m and k as the partitioned Filter built with
+// the same arguments, and derives bit i of an item from the same hash, as
+// (a + b*i) mod m, so that only the layout differs.
+type standard struct {
+	// f hashes items; its partitions are never allocated.
+	f    *bloom.Filter
+	b    *bitset.BitSet
+	m, k uint
+}
+
+func newStandard(n uint, opt ...bloom.Option) *standard {
+	f := bloom.NewLazy(n, opt...)
+	st := f.Stats()
+	return &standard{f: f, b: bitset.New(st.M), m: st.M, k: st.K}
+}
+
+func (sf *standard) Add(item []byte) {
+	a, b := sf.f.Digest(item)
+	for i := uint(0); i < sf.k; i++ {
+		sf.b.Set((uint(a) + uint(b)*i) % sf.m)
+	}
+}
+
+func (sf *standard) Check(item []byte) bool {
+	a, b := sf.f.Digest(item)
+	for i := uint(0); i < sf.k; i++ {
+		if !sf.b.Test((uint(a) + uint(b)*i) % sf.m) {
+			return false
+		}
+	}
+	return true
+}
+
+// BenchmarkLayouts builds every layout in Layouts with matching parameters,
+// sized for len(members) items, and reports ns/op for Add and Check.  The
+// Check benchmark also reports the false-positive rate observed when probing
+// nonMembers, which must be disjoint from members.
+//
+// The options in opt are passed to every layout, so that the comparison is
+// made at identical error rate, fill ratio and hash function.
+func BenchmarkLayouts(b *testing.B, members, nonMembers [][]byte, opt ...bloom.Option) {
+	if len(members) == 0 || len(nonMembers) == 0 {
+		b.Fatal("members and nonMembers must not be empty")
+	}
+
+	n := uint(len(members))
+
+	for _, l := range Layouts {
+		l := l
+
+		b.Run(l.Name+"/Add", func(b *testing.B) {
+			f := l.New(n, opt...)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				f.Add(members[i%len(members)])
+			}
+		})
+
+		b.Run(l.Name+"/Check", func(b *testing.B) {
+			f := l.New(n, opt...)
+			for _, m := range members {
+				f.Add(m)
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				f.Check(nonMembers[i%len(nonMembers)])
+			}
+
+			b.StopTimer()
+
+			b.ReportMetric(FalsePositiveRate(f, nonMembers), "fpr")
+		})
+	}
+}
+
+// FalsePositiveRate returns the fraction of nonMembers reported as present
+// by f.
+func FalsePositiveRate(f Filter, nonMembers [][]byte) float64 {
+	if len(nonMembers) == 0 {
+		return 0
+	}
+
+	fp := 0
+	for _, m := range nonMembers {
+		if f.Check(m) {
+			fp++
+		}
+	}
+
+	return float64(fp) / float64(len(nonMembers))
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bench
+
+import (
+	"bufio"
+	"os"
+	"testing"
+
+	"github.com/blocknative/bloom"
+)
+
+// readLines returns the lines of the file at path or, if it does not exist, the
+// n bloom.SyntheticKeys of seed, so that the tests measure false positives
+// meaningfully even without the dictionaries.
+func readLines(t testing.TB, path string, n int, seed uint64) [][]byte {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return bloom.SyntheticKeys(n, seed)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, []byte(scanner.Text()))
+	}
+
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	t.Parallel()
+
+	members := readLines(t, "../testdata/web2.golden", 235886, 1)
+	nonMembers := readLines(t, "../testdata/web2a.golden", 76205, 2)
+
+	for _, l := range Layouts {
+		f := l.New(uint(len(members)))
+		for _, m := range members {
+			f.Add(m)
+		}
+
+		if fpr := FalsePositiveRate(f, members); fpr != 1 {
+			t.Errorf("%s: members reported absent (rate %.4f)", l.Name, fpr)
+		}
+
+		if fpr := FalsePositiveRate(f, nonMembers); fpr > 0.01 {
+			t.Errorf("%s: false-positive rate %.4f exceeds 1%%", l.Name, fpr)
+		}
+	}
+}
+
+func BenchmarkWeb2(b *testing.B) {
+	BenchmarkLayouts(b,
+		readLines(b, "../testdata/web2.golden", 235886, 1),
+		readLines(b, "../testdata/web2a.golden", 76205, 2),
+		bloom.WithErrorRate(.001))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+// blockWords is the number of words of a block of a BlockedFilter, 512 bits,
+// the size of a cache line on common hardware.
+const blockWords = 8
+
+// BlockedFilter is a blocked bloom filter: the bits of each item all lie in
+// one 512-bit block, chosen by its hash, so that Add and Check touch a single
+// cache line rather than one per partition.  This makes Check of items in
+// filters too large for the cache much faster, at the cost of a somewhat higher
+// false-positive rate than a Filter of the same size, since blocks fill
+// unevenly.
+type BlockedFilter struct {
+	// f holds the size, hasher and parameters; its partitions are not
+	// allocated.
+	f *Filter
+
+	// blocks holds the bits, blockWords words per block.
+	blocks []uint64
+
+	// nb is the number of blocks.
+	nb uint
+}
+
+// NewBlocked initializes a new blocked bloom filter, with as many bits as a
+// Filter created with the same arguments, rounded up to whole blocks.
+// n is the number of items the filter is predicted to hold.
+//
+// The options sizing and hashing a Filter apply, except WithIndexReducer and
+// WithTripleHashing, since the bits of an item are located within its block
+// instead; it panics on those, on WithCounterBits, and on options that only a
+// Filter or ScalableFilter implements, such as WithWAL and WithGuard.
+func NewBlocked(n uint, opt ...Option) *BlockedFilter {
+	f, err := newFilter(n, opt)
+	if err != nil {
+		panic(err)
+	}
+
+	name := f.unsupported()
+	switch {
+	case f.reducer != nil:
+		name = "WithIndexReducer"
+	case f.triple:
+		name = "WithTripleHashing"
+	case f.counterBits != 0:
+		name = "WithCounterBits"
+	}
+	if name != "" {
+		panic(name + " is not supported by a BlockedFilter")
+	}
+
+	nb := (f.m + blockWords*64 - 1) / (blockWords * 64)
+	return &BlockedFilter{
+		f:      f,
+		blocks: make([]uint64, nb*blockWords),
+		nb:     nb,
+	}
+}
+
+func (bf *BlockedFilter) Add(item []byte) {
+	block, b := bf.locate(bf.f.digest(bf.f.h, item))
+	for i := uint32(0); i < uint32(bf.f.k); i++ {
+		v := bitInBlock(b, i)
+		block[v/64] |= 1 << (v % 64)
+	}
+	bf.f.c++
+}
+
+// Check returns true if item may be in the filter, and false if it
+// definitely is not.  Like Filter.Check, it may be called concurrently, as
+// long as no goroutine adds to the filter.
+func (bf *BlockedFilter) Check(item []byte) bool {
+	block, b := bf.locate(bf.f.sum(item))
+	for i := uint32(0); i < uint32(bf.f.k); i++ {
+		v := bitInBlock(b, i)
+		if block[v/64]&(1<<(v%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (bf *BlockedFilter) Count() uint {
+	return bf.f.c
+}
+
+func (bf *BlockedFilter) Reset() {
+	for i := range bf.blocks {
+		bf.blocks[i] = 0
+	}
+	bf.f.c = 0
+}
+
+// SizeInBytes returns the memory used by the filter's blocks.
+func (bf *BlockedFilter) SizeInBytes() uint {
+	return uint(len(bf.blocks)) * 8
+}
+
+// locate returns the block for the words (a, b, c), chosen by a, and the word
+// from which the bits within it are derived.
+func (bf *BlockedFilter) locate(a, b, _ uint32) ([]uint64, uint32) {
+	i := uint(a) % bf.nb * blockWords
+	return bf.blocks[i : i+blockWords : i+blockWords], b
+}
+
+// bitInBlock returns the i-th bit location in [0, 512) derived from b, by
+// double hashing on its low and high bits.
+func bitInBlock(b, i uint32) uint32 {
+	return (b + i*(b>>9|1)) % (blockWords * 64)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBlockedFilter(t *testing.T) {
+	t.Parallel()
+
+	bf := NewBlocked(uint(len(web2)), WithErrorRate(0.01))
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	if bf.Count() != uint(len(web2)) {
+		t.Fatalf("expected count %d, got %d", len(web2), bf.Count())
+	}
+
+	for _, w := range web2 {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be found", w)
+		}
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	// Blocks fill unevenly, raising the rate somewhat above the target.
+	if rate := float64(fp) / float64(len(web2a)); rate > 0.03 {
+		t.Errorf("expected a false-positive rate near 0.01, got %g", rate)
+	}
+
+	if bf.Reset(); bf.Count() != 0 || bf.Check([]byte(web2[0])) {
+		t.Error("expected an empty filter after Reset")
+	}
+}
+
+func TestBlockedUnsupportedOptions(t *testing.T) {
+	t.Parallel()
+
+	for name, opt := range map[string]Option{
+		"WithWAL":           WithWAL(io.Discard),
+		"WithFillCounter":   WithFillCounter(),
+		"WithGuard":         WithGuard(1000),
+		"WithIndexReducer":  WithIndexReducer(func(h uint64, bound uint) uint { return uint(h % uint64(bound)) }),
+		"WithTripleHashing": WithTripleHashing(),
+		"WithCounterBits":   WithCounterBits(8),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %s to panic", name)
+				}
+			}()
+			NewBlocked(1000, opt)
+		}()
+	}
+
+	// Options hashing items apply.
+	NewBlocked(1000, WithKeyTransform(bytes.ToLower), WithHashSeed(1))
+}
+
+func BenchmarkBlockedCheck(b *testing.B) {
+	bf := NewBlocked(10000000)
+	benchmarkLargeCheck(b, bf.Add, bf.Check)
+}
+
+func BenchmarkFilterCheck(b *testing.B) {
+	bf := New(10000000)
+	benchmarkLargeCheck(b, bf.Add, bf.Check)
+}
+
+// benchmarkLargeCheck checks the items of web2 in a filter sized for ten
+// million items, too large to stay in cache.  Misses mostly stop at the first
+// unset bit, so hits show the difference between the layouts.
+func benchmarkLargeCheck(b *testing.B, add func([]byte), check func([]byte) bool) {
+	for _, w := range web2 {
+		add([]byte(w))
+	}
+
+	items := make([][]byte, len(web2))
+	for i, w := range web2 {
+		items[i] = []byte(w)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		check(items[i%len(items)])
+	}
+}
@@ -17,11 +17,35 @@ package bloom
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
 	"math"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/bits-and-blooms/bitset"
 )
 
+// ErrIncompatible is returned when combining filters of different geometry.
+var ErrIncompatible = errors.New("bloom: filters have different geometry")
+
+// Checker is implemented by filters that can test for membership.
+type Checker interface {
+	Check(item []byte) bool
+}
+
+// Adder is implemented by filters that can record membership.
+type Adder interface {
+	Add(item []byte)
+}
+
+// Counter is implemented by filters that count the items added to them.
+type Counter interface {
+	Count() uint
+}
+
 // Filter is the standard implementation used by this package.  It is a
 // variant implementation of the standard bloom filter that reduces the risk
 // of false-positives by assigning a bit array to each hash function.
@@ -30,6 +54,12 @@ import (
 //
 // The name Partitioned Bloom Filter is my choice as there was no name assigned to f variant.
 type Filter struct {
+	// ones is the number of bits set across all partitions, maintained
+	// atomically when the fill counter is enabled.  It is the first field to
+	// guarantee the 64-bit alignment atomic access requires on 32-bit
+	// platforms.
+	ones uint64
+
 	params
 
 	// m is the total number of bits for f bloom filter. m for the partitioned bloom filter
@@ -65,13 +95,57 @@ type Filter struct {
 
 	// bs holds the list of bits to be set/check based on the hash values
 	bs []uint
+
+	// fprFired records whether the FPR callback has fired since the last Reset.
+	fprFired bool
+
+	// walErr is the first error writing to the write-ahead log.
+	walErr error
 }
 
 // New initializes a new partitioned bloom filter.
 // n is the number of items f bloom filter predicted to hold.
+// It panics if the filter cannot be created; see NewChecked.
 func New(n uint, opt ...Option) *Filter {
+	f, err := NewChecked(n, opt...)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+// NewChecked is like New, but returns an error rather than panicking if n is
+// zero, the error rate or fill ratio is not in (0, 1), or the filter would
+// need more bits than allowed by WithMaxBits.
+func NewChecked(n uint, opt ...Option) (*Filter, error) {
+	f, err := newFilter(n, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	f.b = makePartitions(f.k, f.s)
+
+	return f, nil
+}
+
+// NewLazy is like New, but defers allocating the filter's partitions until
+// an item is first added, so that filters created speculatively, such as one
+// per potential shard, cost little memory until they are used.  Check
+// returns false without hashing until then.
+func NewLazy(n uint, opt ...Option) *Filter {
+	f, err := newFilter(n, opt)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+// newFilter returns a filter sized for n items, without its partitions.
+func newFilter(n uint, opt []Option) (*Filter, error) {
 	if n == 0 {
-		panic("n == 0")
+		return nil, errors.New("bloom: n == 0")
 	}
 
 	var f = Filter{n: n}
@@ -79,13 +153,94 @@ func New(n uint, opt ...Option) *Filter {
 		option(&f.params)
 	}
 
-	f.k = k(f.e)
-	f.m = m(n, f.p, f.e)
-	f.s = s(f.m, f.k)
-	f.b = makePartitions(f.k, f.s)
+	if err := checkRates(f.e, f.p); err != nil {
+		return nil, err
+	}
+
+	// Check the size before converting it to an integer, which may overflow.
+	limit := f.maxBits
+	if limit > uint64(^uint(0)) {
+		limit = uint64(^uint(0))
+	}
+	bits := idealBits(n, f.p, f.e)
+	if f.totalBits > 0 {
+		bits = float64(f.totalBits)
+	}
+	if bits > float64(limit) {
+		return nil, fmt.Errorf("bloom: filter needs %.0f bits, exceeding the limit of %d", bits, limit)
+	}
+
+	f.k = f.hashes(f.e)
+	f.m = f.size(n, f.e)
+	f.s = S(f.m, f.k)
 	f.bs = make([]uint, f.k)
 
-	return &f
+	return &f, nil
+}
+
+// Stats is a snapshot of a filter's geometry and load.
+type Stats struct {
+	// N is the number of items the filter was sized for.
+	N uint `json:"n"`
+
+	// M, K and S are the total number of bits, the number of partitions
+	// and the number of bits per partition.
+	M uint `json:"m"`
+	K uint `json:"k"`
+	S uint `json:"s"`
+
+	// Count is the number of items added.
+	Count uint `json:"count"`
+
+	// ErrorRate and MaxFillRatio are the configured e and p.
+	ErrorRate    float64 `json:"error_rate"`
+	MaxFillRatio float64 `json:"max_fill_ratio"`
+
+	// FillRatio and EstimatedFillRatio are as returned by the methods of
+	// the same name.
+	FillRatio          float64 `json:"fill_ratio"`
+	EstimatedFillRatio float64 `json:"estimated_fill_ratio"`
+}
+
+// Stats returns a snapshot of the filter's geometry and load.
+func (f *Filter) Stats() Stats {
+	return Stats{
+		N:                  f.n,
+		M:                  f.m,
+		K:                  f.k,
+		S:                  f.s,
+		Count:              f.c,
+		ErrorRate:          f.e,
+		MaxFillRatio:       f.p,
+		FillRatio:          f.FillRatio(),
+		EstimatedFillRatio: f.EstimatedFillRatio(),
+	}
+}
+
+// Clone returns a deep copy of the filter.  If the filter's hasher implements
+// HashCloner, the copy gets a clone of it, and the two filters may be used
+// concurrently; otherwise the copy shares the original's hasher, so they must
+// not be.  Replacing the hasher with a fresh one of another kind would map
+// items to different bits, breaking the copy's membership answers.  The copy
+// does not write to the original's write-ahead log, if any.
+func (f *Filter) Clone() *Filter {
+	g := *f
+	g.ones = atomic.LoadUint64(&f.ones)
+	g.wal, g.walErr = nil, nil
+
+	if c, ok := f.h.(HashCloner); ok {
+		g.h = c.Clone()
+	}
+
+	if f.b != nil {
+		g.b = make([]*bitset.BitSet, len(f.b))
+		for i, b := range f.b {
+			g.b[i] = b.Clone()
+		}
+	}
+	g.bs = make([]uint, len(f.bs))
+
+	return &g
 }
 
 func (f *Filter) Reset() {
@@ -93,32 +248,447 @@ func (f *Filter) Reset() {
 		b.ClearAll()
 	}
 
+	f.c = 0
+	f.fprFired = false
+	atomic.StoreUint64(&f.ones, 0)
 	f.h.Reset()
 }
 
+// Resize empties the filter like Reset, and resizes it for n items, keeping
+// its hasher and options.  opt are applied on top of the filter's options,
+// e.g. to change the error rate.  Unlike New, it returns an error rather than
+// panicking if the filter cannot be sized, in which case it is unchanged.
+func (f *Filter) Resize(n uint, opt ...Option) error {
+	keep := func(ps *params) {
+		*ps = f.params
+	}
+
+	g, err := newFilter(n, append([]Option{keep}, opt...))
+	if err != nil {
+		return err
+	}
+
+	if f.b != nil {
+		g.b = makePartitions(g.k, g.s)
+	}
+	g.h.Reset()
+
+	*f = *g
+	return nil
+}
+
+// Merge adds the items of other, which must have the same geometry, to the
+// filter by ORing their partitions.  The count of other is added to the
+// filter's, overcounting items present in both.  See AbsorbBits to leave the
+// count alone.
+func (f *Filter) Merge(other *Filter) error {
+	if f.k != other.k || f.s != other.s || f.m != other.m {
+		return ErrIncompatible
+	}
+
+	f.union(other)
+	f.c += other.c
+
+	return nil
+}
+
+// Equal reports whether other has the same geometry and error rate as the
+// filter and the same bits set, so that the two answer every Check alike.
+// Filters built from the same items with the same hasher are equal whatever
+// the order the items were added in; their counts are not compared.
+func (f *Filter) Equal(other *Filter) bool {
+	if f.m != other.m || f.k != other.k || f.s != other.s || f.e != other.e {
+		return false
+	}
+
+	a, b := f.partitions(), other.partitions()
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AbsorbBits ORs the partitions of src, which must have the same geometry,
+// into the filter like Merge, but leaves the count unchanged.  Use it rather
+// than Merge when the number of distinct items is tracked separately, e.g.
+// because src and the filter share many items, so that summing their counts
+// would overstate it; the count, and hence EstimatedFillRatio, is then up to
+// the caller.  It returns ErrIncompatible if the geometries differ.
+func (f *Filter) AbsorbBits(src *Filter) error {
+	if f.k != src.k || f.s != src.s || f.m != src.m {
+		return ErrIncompatible
+	}
+
+	f.union(src)
+	return nil
+}
+
+// union ORs the partitions of other into the filter's.
+func (f *Filter) union(other *Filter) {
+	if other.b != nil {
+		f.allocate()
+		for i, b := range f.b {
+			b.InPlaceUnion(other.b[i])
+		}
+	}
+
+	if f.fillCounter {
+		atomic.StoreUint64(&f.ones, uint64(f.count()))
+	}
+}
+
+// Verify checks the filter's internal invariants, returning an error that
+// describes the first violation found.  It is meant to be called after
+// loading a filter from an untrusted source.
+func (f *Filter) Verify() error {
+	switch {
+	case f.k == 0 || f.s == 0:
+		return fmt.Errorf("bloom: empty geometry (k = %d, s = %d)", f.k, f.s)
+	case f.b != nil && uint(len(f.b)) != f.k:
+		return fmt.Errorf("bloom: %d partitions, expected k = %d", len(f.b), f.k)
+	case uint(len(f.bs)) != f.k:
+		return fmt.Errorf("bloom: %d bit locations, expected k = %d", len(f.bs), f.k)
+	case S(f.m, f.k) != f.s:
+		return fmt.Errorf("bloom: partition size %d does not match m = %d and k = %d", f.s, f.m, f.k)
+	case f.h == nil:
+		return errors.New("bloom: no hasher")
+	}
+
+	for i, b := range f.b {
+		if b == nil {
+			return fmt.Errorf("bloom: partition %d is nil", i)
+		}
+		if b.Len() < f.s {
+			return fmt.Errorf("bloom: partition %d has %d bits, expected at least s = %d", i, b.Len(), f.s)
+		}
+	}
+
+	return nil
+}
+
+// Partitions returns the filter's k partition bitsets, for running bitset
+// operations directly.  The returned slice aliases the filter's internal
+// state and should be treated as read-mostly: bits set or cleared through it
+// are seen by Check, but bypass the item count and the fill counter, so that
+// Count, EstimatedFillRatio and FillRatio (with WithFillCounter) no longer
+// reflect the bits.  Clearing bits causes false negatives.  The partitions of
+// a filter created with NewLazy are allocated first.
+func (f *Filter) Partitions() []*bitset.BitSet {
+	f.allocate()
+	return f.b
+}
+
+// allocate allocates the partitions of a lazy filter, if not yet allocated.
+func (f *Filter) allocate() {
+	if f.b == nil {
+		f.b = makePartitions(f.k, f.s)
+	}
+}
+
+// partitions returns the filter's partitions, or empty ones if they are not
+// allocated, without allocating them.
+func (f *Filter) partitions() []*bitset.BitSet {
+	if f.b == nil {
+		return makePartitions(f.k, f.s)
+	}
+	return f.b
+}
+
+// Capacity returns the number of items the filter was sized for.
+func (f *Filter) Capacity() uint {
+	return f.n
+}
+
+// Remaining returns the number of items that may be added before the filter
+// exceeds its capacity.  It is negative once the filter is overloaded.
+func (f *Filter) Remaining() int {
+	return int(f.n) - int(f.c)
+}
+
+// ErrorRate returns the error rate the filter was configured with.
+func (f *Filter) ErrorRate() float64 {
+	return f.e
+}
+
+// SizeInBytes returns the memory used by the filter, including its bit
+// partitions and fixed overhead but excluding the hasher.
+func (f *Filter) SizeInBytes() uint {
+	return footprint(f.k, f.s)
+}
+
 func (f *Filter) EstimatedFillRatio() float64 {
 	return 1 - math.Exp(-float64(f.c)/float64(f.s))
 }
 
+// EstimatedFalsePositiveRate estimates the filter's current false-positive
+// rate from its count: the probability that the bit tested in every
+// partition is set, EstimatedFillRatio^k.
+func (f *Filter) EstimatedFalsePositiveRate() float64 {
+	return math.Pow(f.EstimatedFillRatio(), float64(f.k))
+}
+
+// CurrentErrorRate returns the filter's false-positive rate as measured from
+// the bits actually set: the product of the fill ratios of its partitions.
+// Unlike EstimatedFalsePositiveRate, it reflects repeated and merged items,
+// and once the filter is overloaded it climbs above the configured error rate.
+func (f *Filter) CurrentErrorRate() float64 {
+	if f.b == nil {
+		return 0
+	}
+
+	e := 1.0
+	for _, b := range f.b {
+		e *= float64(b.Count()) / float64(f.s)
+	}
+	return e
+}
+
+// CompareFPR returns the ratio of the filter's EstimatedFalsePositiveRate to
+// that of other, e.g. to compare configurations loaded with the same data.
+// A ratio below 1 means the filter is the more accurate.  It is NaN if
+// neither filter holds any items.
+func (f *Filter) CompareFPR(other *Filter) float64 {
+	return f.EstimatedFalsePositiveRate() / other.EstimatedFalsePositiveRate()
+}
+
+// FillRatio returns the average fill ratio of the filter's partitions.  With
+// WithFillCounter it is read from the fill counter in constant time and is
+// safe to call concurrently with Add; otherwise the partitions are scanned,
+// which is not.
 func (f *Filter) FillRatio() float64 {
+	if f.fillCounter {
+		return float64(atomic.LoadUint64(&f.ones)) / float64(f.s*f.k)
+	}
+
 	// Since f is partitioned, we will return the average fill ratio of all partitions
 	t := float64(0)
-	for _, v := range f.b[:f.k] {
+	for _, v := range f.b {
 		t += (float64(v.Count()) / float64(f.s))
 	}
 	return t / float64(f.k)
 }
 
+// PartitionCount returns the number of bits set in partition i, counted a
+// word at a time with the hardware popcount.  It panics if i >= k.
+func (f *Filter) PartitionCount(i uint) uint {
+	if i >= f.k {
+		panic("partition out of range")
+	}
+
+	if f.b == nil {
+		return 0
+	}
+	return f.b[i].Count()
+}
+
+// MinMaxPartitionFill returns the fill ratios of the least and most full
+// partitions.  Items set one bit in every partition, so the partitions of a
+// well-hashed filter fill alike, and a large spread reveals a hashing
+// imbalance that raises the false-positive rate beyond the estimate.
+func (f *Filter) MinMaxPartitionFill() (min, max float64) {
+	min = 1
+	for _, b := range f.partitions() {
+		r := float64(b.Count()) / float64(f.s)
+		if r < min {
+			min = r
+		}
+		if r > max {
+			max = r
+		}
+	}
+	return min, max
+}
+
+// FillRatioStdDev returns the standard deviation of the fill ratios of the
+// partitions.  Like MinMaxPartitionFill, it reveals a hashing imbalance: the
+// partitions of a well-hashed filter of s bits holding items that set x of
+// them deviate by about sqrt(x*(s-x)/s)/s.
+func (f *Filter) FillRatioStdDev() float64 {
+	var sum, sq float64
+	for _, b := range f.partitions() {
+		r := float64(b.Count()) / float64(f.s)
+		sum += r
+		sq += r * r
+	}
+
+	mean := sum / float64(f.k)
+	v := sq/float64(f.k) - mean*mean
+	if v < 0 {
+		// Rounding may leave a tiny negative variance for equal ratios.
+		return 0
+	}
+	return math.Sqrt(v)
+}
+
+// BitsSet returns the number of bits set across all partitions.  Like
+// FillRatio, it is safe to call concurrently with Add only with
+// WithFillCounter.
+func (f *Filter) BitsSet() uint {
+	if f.fillCounter {
+		return uint(atomic.LoadUint64(&f.ones))
+	}
+	return f.count()
+}
+
+// DensityHistogram bins the fill ratio of every 64-bit word of every
+// partition into the given number of equal-width buckets, the first holding
+// the emptiest words and the last the fullest.  A well-distributed filter
+// yields a bell-shaped histogram centered on its fill ratio, while one that
+// concentrates on the first and last buckets reveals clustered bits and a
+// hashing problem.
+func (f *Filter) DensityHistogram(buckets int) []uint {
+	if buckets <= 0 {
+		return nil
+	}
+
+	h := make([]uint, buckets)
+	for _, b := range f.partitions() {
+		for j, w := range b.Bytes() {
+			n := f.s - uint(j)*64
+			if n > 64 {
+				n = 64
+			}
+
+			i := bits.OnesCount64(w) * buckets / int(n)
+			if i == buckets {
+				i--
+			}
+			h[i]++
+		}
+	}
+	return h
+}
+
+// count returns the number of bits set across all partitions.
+func (f *Filter) count() uint {
+	var t uint
+	for _, v := range f.b {
+		t += v.Count()
+	}
+	return t
+}
+
 func (f *Filter) Add(item []byte) {
+	if f.wal != nil {
+		f.log(item)
+	}
+
 	f.bits(item)
-	for i, v := range f.bs[:f.k] {
-		f.b[i].Set(v)
+	f.set()
+}
+
+// AddIfAbsent adds item unless it may already be present, reporting whether
+// it was added.  The item is hashed once for both the lookup and the
+// insertion, rather than once by each of Check and Add.  A false positive may
+// report a new item present, and leave it out, but an item added before is
+// never reported new.  Repeats are neither counted nor logged.
+func (f *Filter) AddIfAbsent(item []byte) bool {
+	if f.bits(item); f.test() {
+		return false
 	}
-	f.c++
+
+	if f.wal != nil {
+		f.log(item)
+	}
+
+	f.set()
+	return true
 }
 
+// Check returns true if item may be in the filter, and false if it
+// definitely is not.  It computes bit locations on the stack and, unless the
+// filter was given a single hasher with WithHash, hashes with a hasher of its
+// own, so that concurrent calls are safe as long as none adds to the filter.
 func (f *Filter) Check(item []byte) bool {
-	f.bits(item)
+	if f.IsEmpty() {
+		return false
+	}
+
+	var bs [maxStackK]uint
+	a, b, c := f.sum(item)
+	return f.testWords(f.scratch(bs[:]), a, b, c)
+}
+
+// maxStackK is the largest k for which Check computes bit locations without
+// allocating.
+const maxStackK = 32
+
+// scratch returns buf, typically an array of maxStackK elements on the
+// caller's stack, to hold the bit locations of an item, or a new slice if
+// the filter's k exceeds its length.
+func (f *Filter) scratch(buf []uint) []uint {
+	if f.k > uint(len(buf)) {
+		return make([]uint, f.k)
+	}
+	return buf
+}
+
+// withHasher calls fn with a hasher borrowed from the pool, if the filter
+// has one, and with the filter's shared hasher otherwise.
+func (f *Filter) withHasher(fn func(h hash.Hash)) {
+	if f.hashers == nil {
+		fn(f.h)
+		return
+	}
+
+	h := f.hashers.Get().(hash.Hash)
+	fn(h)
+	f.hashers.Put(h)
+}
+
+// sum is like digest with the filter's hasher, but borrows a hasher from the
+// pool, if the filter has one, rather than using the shared hasher.
+func (f *Filter) sum(item []byte) (a, b, c uint32) {
+	f.withHasher(func(h hash.Hash) {
+		a, b, c = f.digest(h, item)
+	})
+	return a, b, c
+}
+
+// CheckWithK is like Check, but only tests the first k partitions, trading
+// accuracy for speed on low-stakes lookups.  Its false-positive rate is about
+// FillRatio^k rather than FillRatio raised to the filter's k, so that at the
+// default fill ratio of 0.5 each partition skipped doubles it.  A k of 0
+// reports every item present, and a k above the filter's is treated as the
+// filter's.  Like Check, it is safe for concurrent use.
+func (f *Filter) CheckWithK(item []byte, k uint) bool {
+	if k > f.k {
+		k = f.k
+	}
+
+	if f.IsEmpty() {
+		return k == 0
+	}
+
+	var bs [maxStackK]uint
+	scratch := f.scratch(bs[:])
+
+	a, b, c := f.sum(item)
+	f.locate(scratch, a, b, c)
+	for i, v := range scratch[:k] {
+		if !f.b[i].Test(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEmpty reports whether the filter holds no items, in which case Check
+// returns false without hashing.  A filter is empty if nothing was added to
+// it and no bit of its first partition is set, since every item sets a bit of
+// each partition.
+func (f *Filter) IsEmpty() bool {
+	return f.b == nil || f.c == 0 && f.b[0].None()
+}
+
+// test reports whether the bits held in bs are all set.
+func (f *Filter) test() bool {
+	if f.b == nil {
+		return false
+	}
+
 	for i, v := range f.bs[:f.k] {
 		if !f.b[i].Test(v) {
 			return false
@@ -131,18 +701,282 @@ func (f *Filter) Count() uint {
 	return f.c
 }
 
+// AddPrehashed adds an item given the two 32-bit words produced by Digest,
+// letting callers hash a key once and feed the result to several structures.
+//
+// The pair (a, b) is the big-endian decoding of bytes [4:8] and [0:4] of the
+// hasher's Sum, and the partition-local index for partition i is
+// (a + b*i) mod s.  The domain is stable for a given hasher: a pair computed
+// by one Filter may be added to any Filter configured with the same hasher,
+// whatever its size.
+//
+// With WithTripleHashing, AddPrehashed sets the bits Add would only for
+// hashers whose Sum is shorter than 16 bytes.
+func (f *Filter) AddPrehashed(a, b uint32) {
+	f.locations(a, b, 0)
+	f.set()
+}
+
+// AddHashPairs adds the items whose precomputed (a, b) pairs are given, as
+// AddPrehashed does for each pair.  External producers must derive each pair
+// from the hasher's Sum of the item as a = big-endian uint32 of bytes [4:8]
+// and b = big-endian uint32 of bytes [0:4]; the item then sets bit
+// (a + b*i) mod s of partition i, for i in [0, k).
+func (f *Filter) AddHashPairs(pairs [][2]uint32) {
+	for _, p := range pairs {
+		f.AddPrehashed(p[0], p[1])
+	}
+}
+
+// Digest returns the two 32-bit words used to derive the bit locations of
+// item.  See AddPrehashed.
+func (f *Filter) Digest(item []byte) (a, b uint32) {
+	a, b, _ = f.digest(f.h, item)
+	return a, b
+}
+
+// Positions returns the bit locations Add and Check use for item, element i
+// being the location in partition i, without modifying the filter.  It helps
+// to find items whose locations collide.
+func (f *Filter) Positions(item []byte) []uint {
+	bs := make([]uint, f.k)
+	a, b, c := f.sum(item)
+	f.locate(bs, a, b, c)
+	return bs
+}
+
+// CheckConcurrent is like Check, but hashes item with h and computes bit
+// locations in scratch rather than using the filter's own hasher and scratch
+// space, so that it only reads the filter.  Any number of goroutines may call
+// it concurrently, as long as each uses its own h and scratch and none adds
+// to the filter.  h must be of the same kind as the filter's hasher, and
+// scratch should have room for k locations, otherwise it is allocated.
+func (f *Filter) CheckConcurrent(item []byte, scratch []uint, h hash.Hash) bool {
+	if f.b == nil {
+		return false
+	}
+
+	if uint(len(scratch)) < f.k {
+		scratch = make([]uint, f.k)
+	}
+
+	a, b, c := f.digest(h, item)
+	return f.testWords(scratch, a, b, c)
+}
+
+// testWords reports whether the bits for the words (a, b, c) are all set,
+// using scratch, which must have room for k locations, rather than bs.
+func (f *Filter) testWords(scratch []uint, a, b, c uint32) bool {
+	f.locate(scratch, a, b, c)
+
+	for i, v := range scratch[:f.k] {
+		if !f.b[i].Test(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// digest hashes item with h and returns the words from which its bit
+// locations are derived.  c is 0 unless WithTripleHashing applies.
+func (f *Filter) digest(h hash.Hash, item []byte) (a, b, c uint32) {
+	if f.transform != nil {
+		item = f.transform(item)
+	}
+
+	f.prime(h)
+	h.Write(item)
+	return f.words(h)
+}
+
+// prime prepares h to hash an item: unless WithNoHashReset applies, it resets
+// h and writes the seed set with WithHashSeed, if any.
+func (f *Filter) prime(h hash.Hash) {
+	if !f.noReset {
+		h.Reset()
+		if f.seeded {
+			h.Write(f.seed[:])
+		}
+	}
+}
+
+// words splits the sum of h into the words (a, b, c).  It reads the sum of a
+// hash.Hash64 with Sum64 to avoid allocating, which assumes that Sum returns
+// its big-endian encoding, as it does for the hashers of the standard library.
+func (f *Filter) words(h hash.Hash) (a, b, c uint32) {
+	if h64, ok := h.(hash.Hash64); ok {
+		x := h64.Sum64()
+		return uint32(x), uint32(x >> 32), 0
+	}
+
+	s := h.Sum(nil)
+	a, b = binary.BigEndian.Uint32(s[4:8]), binary.BigEndian.Uint32(s[0:4])
+	if f.triple && len(s) >= 16 {
+		c = binary.BigEndian.Uint32(s[8:12]) ^ binary.BigEndian.Uint32(s[12:16])
+	}
+	return a, b, c
+}
+
+// set sets the bits held in bs and accounts for the added item.
+func (f *Filter) set() {
+	f.allocate()
+
+	if f.fillCounter {
+		var n uint64
+		for i, v := range f.bs[:f.k] {
+			if !f.b[i].Test(v) {
+				f.b[i].Set(v)
+				n++
+			}
+		}
+		atomic.AddUint64(&f.ones, n)
+	} else {
+		for i, v := range f.bs[:f.k] {
+			f.b[i].Set(v)
+		}
+	}
+	f.c++
+
+	if f.c%fprSampleInterval == 0 {
+		f.sampleFPR()
+	}
+}
+
+// sampleFPR calls the FPR callback if the estimated false-positive rate has
+// drifted beyond the target.
+func (f *Filter) sampleFPR() {
+	if f.onFPR != nil && !f.fprFired {
+		observed := f.EstimatedFalsePositiveRate()
+		if observed > fprAlarmFactor*f.e {
+			f.fprFired = true
+			f.onFPR(observed, f.e)
+		}
+	}
+}
+
+// EstimatedAddsSince estimates the number of distinct items added to the
+// filter since snapshot, an earlier copy of it, from the difference in the
+// number of bits set.  It panics if the two filters differ in geometry.
+func (f *Filter) EstimatedAddsSince(snapshot *Filter) uint {
+	if f.k != snapshot.k || f.s != snapshot.s {
+		panic("geometry mismatch")
+	}
+
+	now, then := f.EstimatedCount(), snapshot.EstimatedCount()
+	if now < then {
+		return 0
+	}
+	return now - then
+}
+
 func (f *Filter) bits(item []byte) {
-	f.h.Reset()
-	f.h.Write(item)
-	s := f.h.Sum(nil)
-	a := binary.BigEndian.Uint32(s[4:8])
-	b := binary.BigEndian.Uint32(s[0:4])
+	f.locations(f.digest(f.h, item))
+}
 
+func (f *Filter) locations(a, b, c uint32) {
+	f.locate(f.bs, a, b, c)
+}
+
+// locate stores the partition-local bit locations for the words (a, b, c) in
+// the first k elements of bs.
+func (f *Filter) locate(bs []uint, a, b, c uint32) {
 	// Reference: Less Hashing, Same Performance: Building a Better Bloom Filter
 	// URL: http://www.eecs.harvard.edu/~kirsch/pubs/bbbf/rsa.pdf
-	for i := range f.bs[:f.k] {
-		f.bs[i] = (uint(a) + uint(b)*uint(i)) % f.s
+	//
+	// Enhanced double hashing, which adds a term such as (i*i+i)/2, reduces
+	// correlation between the locations of a single bit array.  Here each
+	// partition is its own array, and a term depending only on i merely
+	// rotates partition i: items colliding in it still collide, so the
+	// false-positive rate is unchanged and the plain scheme is kept.
+	//
+	// Two items whose a and b agree modulo s collide in every partition,
+	// which puts a floor of about n/s^2 under the false-positive rate.  With
+	// triple hashing the term c*i^2 makes them collide only if c agrees too.
+	if f.reducer != nil {
+		for i := range bs[:f.k] {
+			x := uint64(i)
+			bs[i] = f.reducer(uint64(a)+uint64(b)*x+uint64(c)*x*x, f.s)
+		}
+		return
+	}
+
+	if c != 0 {
+		for i := range bs[:f.k] {
+			x := uint64(i)
+			bs[i] = uint((uint64(a) + uint64(b)*x + uint64(c)*x*x) % uint64(f.s))
+		}
+		return
+	}
+
+	for i := range bs[:f.k] {
+		bs[i] = (uint(a) + uint(b)*uint(i)) % f.s
+	}
+}
+
+// EstimatedCount estimates the number of distinct items added to the filter
+// from the number of bits set in its partitions.  Unlike Count, which counts
+// calls to Add, it is not inflated by repeated items or by merging filters
+// holding the same items, and it is meaningful for a filter whose count was
+// not preserved.
+func (f *Filter) EstimatedCount() uint {
+	// Each item sets one bit per partition, so a partition with x of its s
+	// bits set holds about -s * ln(1 - x/s) items.  Average over partitions.
+	t := float64(0)
+	for _, b := range f.b {
+		t += f.itemsFor(b.Count())
+	}
+	return uint(math.Round(t / float64(f.k)))
+}
+
+// itemsFor estimates the number of items that set x bits of a partition.
+func (f *Filter) itemsFor(x uint) float64 {
+	if x >= f.s {
+		x = f.s - 1
 	}
+	return -float64(f.s) * math.Log(1-float64(x)/float64(f.s))
+}
+
+// IntersectionCount estimates the number of items added to both the filter
+// and other, which must have the same geometry, without modifying either.
+//
+// Rather than estimating it from the bits set in both filters, which also
+// counts bits set by distinct items of each, it applies inclusion-exclusion
+// to estimates of the number of items in each filter and in their union,
+// each derived from the bits set as by EstimatedFillRatio.  The estimate is
+// therefore noisiest for small intersections of large filters.
+func (f *Filter) IntersectionCount(other *Filter) (uint, error) {
+	if f.k != other.k || f.s != other.s || f.m != other.m {
+		return 0, ErrIncompatible
+	}
+
+	var (
+		a, b, union float64
+		ps          = other.partitions()
+	)
+	for i, p := range f.partitions() {
+		q := ps[i]
+
+		var n uint
+		ws := q.Bytes()
+		for j, w := range p.Bytes() {
+			n += uint(bits.OnesCount64(w | ws[j]))
+		}
+
+		a += f.itemsFor(p.Count())
+		b += f.itemsFor(q.Count())
+		union += f.itemsFor(n)
+	}
+
+	if t := (a + b - union) / float64(f.k); t > 0 {
+		return uint(math.Round(t)), nil
+	}
+	return 0, nil
+}
+
+// threshold returns the largest count for which EstimatedFillRatio does not
+// exceed p.
+func (f *Filter) threshold(p float64) uint {
+	return uint(math.Floor(-float64(f.s) * math.Log(1-p)))
 }
 
 func makePartitions(k, s uint) []*bitset.BitSet {
@@ -155,15 +989,33 @@ func makePartitions(k, s uint) []*bitset.BitSet {
 	return b
 }
 
-func k(e float64) uint {
+// footprint returns the memory used by a filter with k partitions of s bits.
+func footprint(k, s uint) uint {
+	var (
+		filter    = uint(unsafe.Sizeof(Filter{}))
+		partition = uint(unsafe.Sizeof(bitset.BitSet{}) + unsafe.Sizeof(&bitset.BitSet{}) + unsafe.Sizeof(uint(0)))
+	)
+	return filter + k*(partition+words(s)*8)
+}
+
+// K returns the number of partitions, or hash functions, a filter needs for a
+// false-positive rate of e.
+func K(e float64) uint {
 	return uint(math.Ceil(math.Log2(1 / e)))
 }
 
-func m(n uint, p, e float64) uint {
+// M returns the total number of bits a filter needs to hold n items with a
+// false-positive rate of e once a fraction p of its bits are set.
+func M(n uint, p, e float64) uint {
+	return uint(math.Ceil(idealBits(n, p, e)))
+}
+
+func idealBits(n uint, p, e float64) float64 {
 	// m =~ n / ((log(p)*log(1-p))/abs(log e))
-	return uint(math.Ceil(float64(n) / ((math.Log(p) * math.Log(1-p)) / math.Abs(math.Log(e)))))
+	return float64(n) / ((math.Log(p) * math.Log(1-p)) / math.Abs(math.Log(e)))
 }
 
-func s(m, k uint) uint {
+// S returns the number of bits of each of k partitions sharing m bits.
+func S(m, k uint) uint {
 	return uint(math.Ceil(float64(m) / float64(k)))
 }
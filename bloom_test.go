@@ -17,15 +17,23 @@ package bloom
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"crypto/sha1"
+	"encoding"
+	"encoding/binary"
 	"fmt"
 	"hash"
 	"hash/crc64"
 	"hash/fnv"
+	"math"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/bits-and-blooms/bitset"
 	"github.com/spaolacci/murmur3"
 	"github.com/zentures/cityhash"
 )
@@ -33,35 +41,39 @@ import (
 var web2, web2a []string
 
 func init() {
-	f, err := os.Open("testdata/web2.golden")
+	web2 = corpus("testdata/web2.golden", 235886, 1)
+	web2a = corpus("testdata/web2a.golden", 76205, 2)
+}
+
+// corpus returns the lines of the file at path or, if it does not exist, the n
+// SyntheticKeys of seed, so that the tests measure false positives
+// meaningfully even without the dictionaries.
+func corpus(path string, n int, seed uint64) []string {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		lines := make([]string, n)
+		for i, key := range SyntheticKeys(n, seed) {
+			lines[i] = string(key)
+		}
+		return lines
+	}
 	if err != nil {
 		panic(err)
 	}
 	defer f.Close()
 
+	var lines []string
+
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		web2 = append(web2, scanner.Text())
+		lines = append(lines, scanner.Text())
 	}
 
 	if err = scanner.Err(); err != nil {
 		panic(err)
 	}
 
-	f, err2 := os.Open("testdata/web2a.golden")
-	if err2 != nil {
-		panic(err)
-	}
-	defer f.Close()
-
-	scanner = bufio.NewScanner(f)
-	for scanner.Scan() {
-		web2a = append(web2a, scanner.Text())
-	}
-
-	if err2 = scanner.Err(); err2 != nil {
-		panic(err)
-	}
+	return lines
 }
 
 type filter interface {
@@ -110,6 +122,35 @@ func BenchmarkBloomFNV64(b *testing.B) {
 	b.StopTimer()
 }
 
+// BenchmarkBloomFNV64Reset and BenchmarkBloomFNV64NoReset measure the cost
+// saved by skipping the hasher Reset.  fnv is not safe to reuse without Reset,
+// so only the timing of these benchmarks is meaningful.
+func BenchmarkBloomFNV64Reset(b *testing.B) {
+	benchmarkAdd(b, WithHash(fnv.New64()))
+}
+
+func BenchmarkBloomFNV64NoReset(b *testing.B) {
+	benchmarkAdd(b, WithHash(fnv.New64()), WithNoHashReset())
+}
+
+func benchmarkAdd(b *testing.B, opt ...Option) {
+	var lines []string
+	lines = append(lines, web2...)
+	for len(lines) < b.N {
+		lines = append(lines, web2...)
+	}
+
+	bf := New(uint(b.N), opt...)
+
+	b.ResetTimer()
+
+	for l := 0; l < b.N; l++ {
+		bf.Add([]byte(lines[l]))
+	}
+
+	b.StopTimer()
+}
+
 func BenchmarkBloomCRC64(b *testing.B) {
 	var lines []string
 	lines = append(lines, web2...)
@@ -233,3 +274,1284 @@ func testBloomFilter(t *testing.T, f filter) {
 	fmt.Printf("Total false negatives: %d (%.4f%%)\n", fn, (float32(fn) / float32(len(web2)) * 100))
 	fmt.Printf("Total false positives: %d (%.4f%%)\n", fp, (float32(fp) / float32(len(web2a)) * 100))
 }
+
+func TestAddPrehashed(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	pre := New(10000)
+
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+		pre.AddPrehashed(pre.Digest([]byte(w)))
+	}
+
+	for i := range bf.b {
+		if !bf.b[i].Equal(pre.b[i]) {
+			t.Fatalf("partition %d differs between Add and AddPrehashed", i)
+		}
+	}
+
+	if bf.Count() != pre.Count() {
+		t.Errorf("expected count %d, got %d", bf.Count(), pre.Count())
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000, WithKeyTransform(bytes.ToLower))
+
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(strings.ToUpper(w)))
+	}
+
+	for _, w := range web2[:10000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+}
+
+func TestFPRCallback(t *testing.T) {
+	t.Parallel()
+
+	var (
+		calls            int
+		observed, target float64
+	)
+
+	bf := New(1000, WithFPRCallback(func(o, t float64) {
+		calls++
+		observed, target = o, t
+	}))
+
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	if calls != 0 {
+		t.Fatalf("expected no callback at capacity, got %d", calls)
+	}
+
+	for _, w := range web2[1000:20000] {
+		bf.Add([]byte(w))
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected one callback for an overloaded filter, got %d", calls)
+	}
+
+	if target != bf.e || observed <= 2*target {
+		t.Errorf("unexpected callback arguments (%g, %g)", observed, target)
+	}
+}
+
+func TestEstimatedAddsSince(t *testing.T) {
+	t.Parallel()
+
+	bf := New(20000)
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot Filter
+	if err = snapshot.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range web2[5000:15000] {
+		bf.Add([]byte(w))
+	}
+
+	if n := bf.EstimatedAddsSince(&snapshot); n < 9500 || n > 10500 {
+		t.Errorf("expected about 10000 adds, estimated %d", n)
+	}
+}
+
+func TestFillCounter(t *testing.T) {
+	t.Parallel()
+
+	bf := New(100000, WithFillCounter())
+	plain := New(100000)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 40000; i += 4 {
+				mu.Lock()
+				bf.Add([]byte(web2[i]))
+				mu.Unlock()
+			}
+		}(g)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	last := float64(0)
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		r := bf.FillRatio()
+		if r < last {
+			t.Fatalf("fill ratio decreased from %f to %f", last, r)
+		}
+		last = r
+	}
+
+	for _, w := range web2[:40000] {
+		plain.Add([]byte(w))
+	}
+
+	if bf.BitsSet() != plain.BitsSet() {
+		t.Errorf("expected counter %d to match scanned count %d", bf.BitsSet(), plain.BitsSet())
+	}
+
+	if d := bf.FillRatio() - plain.FillRatio(); d > 1e-9 || d < -1e-9 {
+		t.Errorf("expected fill ratio %f, got %f", plain.FillRatio(), bf.FillRatio())
+	}
+}
+
+func TestAddHashPairs(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	pairs := make([][2]uint32, 0, 10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+		a, b := bf.Digest([]byte(w))
+		pairs = append(pairs, [2]uint32{a, b})
+	}
+
+	pre := New(10000)
+	pre.AddHashPairs(pairs)
+
+	for i := range bf.b {
+		if !bf.b[i].Equal(pre.b[i]) {
+			t.Fatalf("partition %d differs between Add and AddHashPairs", i)
+		}
+	}
+}
+
+func TestDensityHistogram(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	h := bf.DensityHistogram(9)
+
+	var low, high, total uint
+	for i := 0; i < 4; i++ {
+		low += h[i]
+		high += h[8-i]
+	}
+	for _, n := range h {
+		total += n
+	}
+
+	if d := float64(low) - float64(high); d > 0.2*float64(low+high) || -d > 0.2*float64(low+high) {
+		t.Errorf("expected a roughly symmetric histogram, got %v", h)
+	}
+
+	if h[4] < total/2 || h[0] != 0 || h[8] != 0 {
+		t.Errorf("expected words to concentrate around half full, got %v", h)
+	}
+
+	// Map every item to one of the first few bits of each partition, so that
+	// the first word fills up and the rest stay empty.
+	values := make([]uint64, 64)
+	for i := range values {
+		values[i] = uint64(i)
+	}
+
+	clustered := New(10000, WithHash(FixedHasher(values...)))
+	for _, w := range web2[:64] {
+		clustered.Add([]byte(w))
+	}
+
+	h = clustered.DensityHistogram(9)
+	if h[8] != clustered.k || h[0] != clustered.k*(uint(len(clustered.b[0].Bytes()))-1) {
+		t.Errorf("expected a skewed histogram, got %v", h)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	t.Parallel()
+
+	const a, b = 7, 11
+
+	bf := New(1000, WithHash(FixedHasher(b<<32|a)))
+	if bf.Check([]byte("foo")) {
+		t.Fatal("expected an empty filter")
+	}
+
+	for i, p := range bf.Partitions() {
+		p.Set((a + b*uint(i)) % bf.s)
+	}
+
+	if !bf.Check([]byte("foo")) {
+		t.Fatal("expected bits set through Partitions to be seen by Check")
+	}
+
+	bf.Partitions()[0].ClearAll()
+	if bf.Check([]byte("foo")) {
+		t.Error("expected bits cleared through Partitions to be seen by Check")
+	}
+}
+
+func TestNewCheckedMaxBits(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewChecked(0); err == nil {
+		t.Error("expected an error for n == 0")
+	}
+
+	if _, err := NewChecked(1e9, WithErrorRate(1e-12), WithMaxBits(1<<30)); err == nil {
+		t.Error("expected an error for a filter exceeding the bit limit")
+	}
+
+	bf, err := NewChecked(1000, WithMaxBits(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bf.m > 1<<20 {
+		t.Errorf("expected at most %d bits, got %d", 1<<20, bf.m)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected New to panic beyond the bit limit")
+		}
+	}()
+	New(1000, WithMaxBits(100))
+}
+
+func TestNewCheckedRanges(t *testing.T) {
+	t.Parallel()
+
+	for _, opt := range []Option{
+		WithErrorRate(1),
+		WithErrorRate(1.5),
+		WithErrorRate(math.NaN()),
+		WithFillRatio(1),
+		WithFillRatio(2),
+	} {
+		bf, err := NewChecked(1000, opt)
+		if err == nil || bf != nil {
+			t.Errorf("expected an error for an out of range option, got %v", err)
+		}
+	}
+
+	for _, opt := range []Option{WithErrorRate(0.5), WithFillRatio(0.9)} {
+		if _, err := NewChecked(1000, opt); err != nil {
+			t.Errorf("expected no error for an option in range, got %v", err)
+		}
+	}
+}
+
+func TestCheckConcurrent(t *testing.T) {
+	t.Parallel()
+
+	bf := New(20000)
+	for _, w := range web2[:20000] {
+		bf.Add([]byte(w))
+	}
+
+	var (
+		wg     sync.WaitGroup
+		errors = make(chan string, 16)
+	)
+
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			scratch, h := make([]uint, bf.k), cityhash.New64()
+			for i := g; i < 20000; i += 16 {
+				if !bf.CheckConcurrent([]byte(web2[i]), scratch, h) {
+					errors <- web2[i]
+					return
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(errors)
+
+	for w := range errors {
+		t.Errorf("expected %q to be present", w)
+	}
+}
+
+func TestRecommendHasher(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	if got, want := fmt.Sprintf("%T", bf.h), fmt.Sprintf("%T", RecommendHasher()); got != want {
+		t.Fatalf("expected the default hasher to be %s, got %s", want, got)
+	}
+
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	// Allow for sampling noise around the target rate.
+	if rate := float64(fp) / float64(len(web2a)); rate > 1.5*bf.e {
+		t.Errorf("false-positive rate %.5f exceeds the target %.5f", rate, bf.e)
+	}
+}
+
+// BenchmarkHashers compares the candidate hashers on the cost of an Add and
+// the false-positive rate they achieve over web2/web2a.  It backs the choice
+// made by RecommendHasher.
+func BenchmarkHashers(b *testing.B) {
+	hashers := []struct {
+		name string
+		h    func() hash.Hash
+	}{
+		{"FNV64", func() hash.Hash { return fnv.New64() }},
+		{"FNV64a", func() hash.Hash { return fnv.New64a() }},
+		{"CRC64", func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) }},
+		{"Murmur3", func() hash.Hash { return murmur3.New64() }},
+		{"CityHash", func() hash.Hash { return cityhash.New64() }},
+	}
+
+	for _, hs := range hashers {
+		hs := hs
+		b.Run(hs.name, func(b *testing.B) {
+			bf := New(uint(len(web2)), WithHash(hs.h()))
+
+			b.ResetTimer()
+
+			for l := 0; l < b.N; l++ {
+				bf.Add([]byte(web2[l%len(web2)]))
+			}
+
+			b.StopTimer()
+
+			bf = New(uint(len(web2)), WithHash(hs.h()))
+			for _, w := range web2 {
+				bf.Add([]byte(w))
+			}
+
+			fp := 0
+			for _, w := range web2a {
+				if bf.Check([]byte(w)) {
+					fp++
+				}
+			}
+			b.ReportMetric(float64(fp)/float64(len(web2a)), "fpr")
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	if err := bf.Verify(); err != nil {
+		t.Fatalf("expected a new filter to verify, got %v", err)
+	}
+
+	data, _ := bf.MarshalBinary()
+	var decoded Filter
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Verify(); err != nil {
+		t.Fatalf("expected a decoded filter to verify, got %v", err)
+	}
+
+	corrupt := bf.Clone()
+	corrupt.b = corrupt.b[:len(corrupt.b)-1]
+	if err := corrupt.Verify(); err == nil {
+		t.Error("expected a filter missing a partition to fail verification")
+	}
+
+	corrupt = bf.Clone()
+	corrupt.b[0] = bitset.New(corrupt.s - 1)
+	if err := corrupt.Verify(); err == nil {
+		t.Error("expected a filter with a short partition to fail verification")
+	}
+
+	corrupt = bf.Clone()
+	corrupt.s++
+	if err := corrupt.Verify(); err == nil {
+		t.Error("expected a filter with an inconsistent partition size to fail verification")
+	}
+}
+
+// lemire reduces the low 32 bits of hash into [0, bound) with a multiply and a
+// shift rather than a division.
+//
+// Reference: A fast alternative to the modulo reduction (Lemire)
+// URL: https://lemire.me/blog/2016/06/27/a-fast-alternative-to-the-modulo-reduction/
+func lemire(hash uint64, bound uint) uint {
+	return uint((hash & 0xffffffff) * uint64(bound) >> 32)
+}
+
+func TestIndexReducer(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)), WithIndexReducer(lemire))
+	testBloomFilter(t, bf)
+
+	for _, w := range web2 {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / float64(len(web2a)); rate > 1.5*bf.e {
+		t.Errorf("false-positive rate %.5f exceeds the target %.5f", rate, bf.e)
+	}
+}
+
+func BenchmarkReducerModulo(b *testing.B) {
+	benchmarkAdd(b)
+}
+
+func BenchmarkReducerLemire(b *testing.B) {
+	benchmarkAdd(b, WithIndexReducer(lemire))
+}
+
+func TestCompareFPR(t *testing.T) {
+	t.Parallel()
+
+	loose := New(10000, WithErrorRate(.01))
+	tight := New(10000, WithErrorRate(.001))
+	for _, w := range web2[:10000] {
+		loose.Add([]byte(w))
+		tight.Add([]byte(w))
+	}
+
+	for _, bf := range []*Filter{loose, tight} {
+		if fpr := bf.EstimatedFalsePositiveRate(); fpr < bf.e/2 || fpr > bf.e*2 {
+			t.Errorf("e=%g: estimated false-positive rate %g is far from the target", bf.e, fpr)
+		}
+	}
+
+	if r := tight.CompareFPR(loose); r < .1/2 || r > .1*2 {
+		t.Errorf("expected a ratio near 0.1, got %g", r)
+	}
+
+	if r := loose.CompareFPR(loose); r != 1 {
+		t.Errorf("expected a filter to compare equal to itself, got %g", r)
+	}
+}
+
+func TestNewLazy(t *testing.T) {
+	t.Parallel()
+
+	bf := NewLazy(10000)
+	if bf.b != nil {
+		t.Fatal("expected no partitions to be allocated before the first Add")
+	}
+
+	if bf.Check([]byte(web2[0])) || bf.FillRatio() != 0 || bf.Verify() != nil {
+		t.Fatal("expected an unallocated filter to behave as an empty one")
+	}
+
+	empty, err := New(10000).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := bf.MarshalBinary(); !bytes.Equal(data, empty) {
+		t.Error("expected an unallocated filter to encode as an empty one")
+	}
+
+	if c := bf.Clone(); c.b != nil || bf.b != nil {
+		t.Fatal("expected Clone and MarshalBinary not to allocate partitions")
+	}
+
+	bf.Add([]byte(web2[0]))
+	if uint(len(bf.b)) != bf.k {
+		t.Fatalf("expected %d partitions after the first Add, got %d", bf.k, len(bf.b))
+	}
+
+	testBloomFilter(t, bf)
+}
+
+// countingHash counts the items hashed by the hash it wraps.
+type countingHash struct {
+	hash.Hash
+	writes int
+}
+
+func (h *countingHash) Write(p []byte) (int, error) {
+	h.writes++
+	return h.Hash.Write(p)
+}
+
+func TestIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	h := &countingHash{Hash: cityhash.New64()}
+
+	bf := New(1000, WithHash(h))
+	if !bf.IsEmpty() {
+		t.Fatal("expected a new filter to be empty")
+	}
+
+	for _, w := range web2[:100] {
+		if bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be absent from an empty filter", w)
+		}
+	}
+
+	if h.writes != 0 {
+		t.Fatalf("expected Check on an empty filter not to hash, got %d writes", h.writes)
+	}
+
+	bf.Add([]byte(web2[0]))
+	if bf.IsEmpty() || !bf.Check([]byte(web2[0])) {
+		t.Fatal("expected a filter with an item not to be empty")
+	}
+
+	bf.Reset()
+	if !bf.IsEmpty() {
+		t.Fatal("expected a reset filter to be empty")
+	}
+
+	// Bits set directly count, even though no item was added.
+	bf.Partitions()[0].Set(0)
+	if bf.IsEmpty() {
+		t.Error("expected a filter with bits set not to be empty")
+	}
+}
+
+func TestCheckWithK(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	last := -1
+	for k := bf.k; k > bf.k-4; k-- {
+		fp := 0
+		for _, w := range web2a {
+			got := bf.CheckWithK([]byte(w), k)
+			if k == bf.k && got != bf.Check([]byte(w)) {
+				t.Fatalf("expected CheckWithK(%q, k) to equal Check", w)
+			}
+
+			if got {
+				fp++
+			}
+		}
+
+		if fp <= last {
+			t.Errorf("expected fewer partitions to raise false positives, got %d with k=%d and %d with k=%d",
+				fp, k, last, k+1)
+		}
+		last = fp
+	}
+
+	// Like Check, CheckWithK is safe for concurrent readers.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for _, w := range web2[g*1000 : (g+1)*1000] {
+				if !bf.CheckWithK([]byte(w), 3) {
+					t.Errorf("expected %q to be present", w)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestMinMaxPartitionFill(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	min, max := bf.MinMaxPartitionFill()
+	if min > max || max-min > .05 {
+		t.Errorf("expected a small spread for a well-hashed filter, got [%g, %g]", min, max)
+	}
+
+	// A hasher whose a is always 0 maps every item to the first bit of the
+	// first partition, while b spreads them across the others.
+	values := make([]uint64, 1000)
+	for i := range values {
+		values[i] = uint64(i+1) << 32
+	}
+
+	skewed := New(1000, WithHash(FixedHasher(values...)))
+	for _, w := range web2[:1000] {
+		skewed.Add([]byte(w))
+	}
+
+	if min, max := skewed.MinMaxPartitionFill(); max-min < .25 {
+		t.Errorf("expected a large spread for a skewed filter, got [%g, %g]", min, max)
+	}
+}
+
+func TestFillRatioStdDev(t *testing.T) {
+	t.Parallel()
+
+	if d := New(1000).FillRatioStdDev(); d != 0 {
+		t.Errorf("expected no deviation for an empty filter, got %g", d)
+	}
+
+	bf := New(1000, WithHashFunc(func() hash.Hash { return fnv.New64() }))
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	// As in TestMinMaxPartitionFill, every item sets the first bit of the
+	// first partition.
+	values := make([]uint64, 1000)
+	for i := range values {
+		values[i] = uint64(i+1) << 32
+	}
+
+	skewed := New(1000, WithHash(FixedHasher(values...)))
+	for _, w := range web2[:1000] {
+		skewed.Add([]byte(w))
+	}
+
+	d, sd := bf.FillRatioStdDev(), skewed.FillRatioStdDev()
+	if d > .02 || sd < 10*d {
+		t.Errorf("expected a much larger deviation for a skewed filter than with fnv, got %g and %g", sd, d)
+	}
+}
+
+func TestAbsorbBits(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	src := New(10000)
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+	for _, w := range web2[500:1500] {
+		src.Add([]byte(w))
+	}
+
+	if err := bf.AbsorbBits(src); err != nil {
+		t.Fatal(err)
+	}
+
+	if bf.Count() != 1000 {
+		t.Errorf("expected the count to stay 1000, got %d", bf.Count())
+	}
+
+	for _, w := range web2[:1500] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	if err := bf.AbsorbBits(New(100)); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestPartitionCount(t *testing.T) {
+	t.Parallel()
+
+	bf := NewLazy(1000)
+	if bf.PartitionCount(0) != 0 {
+		t.Fatal("expected an unallocated partition to have no bits set")
+	}
+
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	var total uint
+	for i := uint(0); i < bf.k; i++ {
+		var want uint
+		for j := uint(0); j < bf.s; j++ {
+			if bf.b[i].Test(j) {
+				want++
+			}
+		}
+
+		if got := bf.PartitionCount(i); got != want {
+			t.Errorf("partition %d: expected %d bits set, got %d", i, want, got)
+		}
+		total += want
+	}
+
+	if total != bf.BitsSet() {
+		t.Errorf("expected partition counts to sum to %d, got %d", bf.BitsSet(), total)
+	}
+}
+
+func TestIntersectionCount(t *testing.T) {
+	t.Parallel()
+
+	a, b := New(30000), New(30000)
+	for _, w := range web2[:20000] {
+		a.Add([]byte(w))
+	}
+	for _, w := range web2[10000:30000] {
+		b.Add([]byte(w))
+	}
+
+	bitsA, bitsB := a.count(), b.count()
+
+	n, err := a.IntersectionCount(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 9500 || n > 10500 {
+		t.Errorf("expected an intersection of about 10000, got %d", n)
+	}
+
+	if a.count() != bitsA || b.count() != bitsB || a.Count() != 20000 {
+		t.Error("expected the operands to be left unchanged")
+	}
+
+	if n, _ := a.IntersectionCount(New(30000)); n > 100 {
+		t.Errorf("expected no intersection with an empty filter, got %d", n)
+	}
+
+	if _, err := a.IntersectionCount(New(1000)); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+// cloningHash is an FNV-1a hash implementing HashCloner.
+type cloningHash struct {
+	hash.Hash
+}
+
+func (h cloningHash) Clone() hash.Hash {
+	c := fnv.New64a()
+	state, _ := h.Hash.(encoding.BinaryMarshaler).MarshalBinary()
+	c.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	return cloningHash{c}
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+
+	for _, h := range []hash.Hash{cityhash.New64(), cloningHash{fnv.New64a()}} {
+		bf := New(10000, WithHash(h))
+		for _, w := range web2[:5000] {
+			bf.Add([]byte(w))
+		}
+
+		c := bf.Clone()
+
+		_, cloned := h.(HashCloner)
+		if shared := c.h == bf.h; shared == cloned {
+			t.Errorf("%T: expected the hasher to be shared only if it cannot be cloned", h)
+		}
+
+		before := make([]bool, len(web2a))
+		for i, w := range web2a {
+			before[i] = c.Check([]byte(w))
+		}
+
+		for _, w := range web2[5000:10000] {
+			bf.Add([]byte(w))
+		}
+
+		for i, w := range web2a {
+			if c.Check([]byte(w)) != before[i] {
+				t.Fatalf("%T: adding to the original changed the copy's answer for %q", h, w)
+			}
+		}
+
+		for _, w := range web2[:5000] {
+			if !c.Check([]byte(w)) {
+				t.Fatalf("%T: expected %q to be present in the copy", h, w)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range []float64{0.1, 0.01, 0.001, 0.0001} {
+		bf := New(10000, WithErrorRate(e))
+		for _, w := range web2[:5000] {
+			bf.Add([]byte(w))
+		}
+
+		st := bf.Stats()
+		if want := uint(math.Ceil(math.Log2(1 / e))); st.K != want {
+			t.Errorf("e = %g: expected k = %d, got %d", e, want, st.K)
+		}
+
+		if st.N != 10000 || st.Count != 5000 || st.ErrorRate != e || st.MaxFillRatio != 0.5 {
+			t.Errorf("e = %g: unexpected stats %+v", e, st)
+		}
+
+		if st.S*st.K < st.M || st.M < uint(idealBits(10000, 0.5, e)) {
+			t.Errorf("e = %g: inconsistent geometry m = %d, k = %d, s = %d", e, st.M, st.K, st.S)
+		}
+	}
+}
+
+func TestEstimatedCount(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+	}
+
+	if c, n := float64(bf.EstimatedCount()), float64(len(web2)); math.Abs(c-n) > 0.05*n {
+		t.Errorf("expected an estimated count within 5%% of %g, got %g", n, c)
+	}
+
+	// Merging a filter into a copy of itself doubles Count, but adds no
+	// distinct items.
+	c := bf.Clone()
+	if err := c.Merge(bf); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.EstimatedCount() != bf.EstimatedCount() {
+		t.Errorf("expected merging identical filters to keep the estimate %d, got %d", bf.EstimatedCount(), c.EstimatedCount())
+	}
+}
+
+func TestCurrentErrorRate(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000, WithErrorRate(0.01))
+	if e := bf.CurrentErrorRate(); e != 0 {
+		t.Fatalf("expected an empty filter to have an error rate of 0, got %g", e)
+	}
+
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	if e := bf.CurrentErrorRate(); e > 2*bf.e {
+		t.Fatalf("expected an error rate near %g at capacity, got %g", bf.e, e)
+	}
+
+	for _, w := range web2[1000:5000] {
+		bf.Add([]byte(w))
+	}
+
+	if e := bf.CurrentErrorRate(); e < 10*bf.e {
+		t.Errorf("expected an overloaded filter's error rate well above %g, got %g", bf.e, e)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	if bf.Capacity() != 1000 || bf.Remaining() != 1000 {
+		t.Fatalf("expected capacity and remaining 1000, got %d and %d", bf.Capacity(), bf.Remaining())
+	}
+
+	for _, w := range web2[:1010] {
+		bf.Add([]byte(w))
+	}
+
+	if bf.Remaining() != -10 {
+		t.Errorf("expected remaining -10, got %d", bf.Remaining())
+	}
+}
+
+func TestWithTotalBits(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000, WithTotalBits(100000), WithErrorRate(0.01))
+	if bf.m != 100000 || bf.k != 7 || bf.s != 14286 {
+		t.Fatalf("expected m = 100000, k = 7, s = 14286, got %d, %d, %d", bf.m, bf.k, bf.s)
+	}
+
+	testBloomFilter(t, New(uint(len(web2)), WithTotalBits(1<<22)))
+
+	if _, err := NewChecked(1000, WithTotalBits(1<<20), WithMaxBits(1<<10)); err == nil {
+		t.Error("expected an error sizing beyond the maximum number of bits")
+	}
+}
+
+func TestWithHashCount(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)), WithHashCount(7))
+	if len(bf.b) != 7 || bf.k != 7 {
+		t.Fatalf("expected 7 partitions, got %d", len(bf.b))
+	}
+	testBloomFilter(t, bf)
+
+	if bf := New(1000, WithHashCount(0), WithErrorRate(0.01)); bf.k != 7 {
+		t.Errorf("expected k computed from the error rate, 7, got %d", bf.k)
+	}
+
+	// Filters with different error rates share a geometry if given the
+	// same k and size.
+	a := New(1000, WithHashCount(5), WithTotalBits(10000), WithErrorRate(0.01))
+	b := New(1000, WithHashCount(5), WithTotalBits(10000), WithErrorRate(0.001))
+	if err := a.Merge(b); err != nil {
+		t.Errorf("expected filters with the same k and m to merge, got %v", err)
+	}
+}
+
+func TestTripleHashing(t *testing.T) {
+	t.Parallel()
+
+	// At n = 20000 and e = 1e-6, double hashing's floor of about n/s^2 is
+	// far above e.
+	keys := SyntheticKeys(1000000, 3)
+	fpr := func(opt ...Option) float64 {
+		bf := New(20000, append(opt, WithErrorRate(1e-6))...)
+		for _, w := range web2[:20000] {
+			bf.Add([]byte(w))
+		}
+		for _, w := range web2[:20000] {
+			if !bf.Check([]byte(w)) {
+				t.Fatalf("expected %q to be found", w)
+			}
+		}
+
+		fp := 0
+		for _, k := range keys {
+			if bf.Check([]byte(k)) {
+				fp++
+			}
+		}
+		return float64(fp) / float64(len(keys))
+	}
+
+	double := fpr(WithHash(murmur3.New128()))
+	triple := fpr(WithHash(murmur3.New128()), WithTripleHashing())
+	if triple >= double/4 {
+		t.Errorf("expected triple hashing to lower the false-positive rate well below %g, got %g", double, triple)
+	}
+
+	// Shorter hashers are unaffected.
+	a, b := New(1000), New(1000, WithTripleHashing())
+	for _, w := range web2[:1000] {
+		a.Add([]byte(w))
+		b.Add([]byte(w))
+	}
+	for i := range a.b {
+		if !a.b[i].Equal(b.b[i]) {
+			t.Fatal("expected triple hashing to have no effect with a 64-bit hasher")
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+
+	keys := append([]string(nil), web2[:10000]...)
+	a := New(10000)
+	for _, w := range keys {
+		a.Add([]byte(w))
+	}
+
+	rand.New(rand.NewSource(1)).Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	b := New(10000)
+	for _, w := range keys {
+		b.Add([]byte(w))
+	}
+
+	if !a.Equal(b) {
+		t.Fatal("expected filters over the same items in a different order to be equal")
+	}
+
+	if b.Add([]byte("extra")); a.Equal(b) {
+		t.Error("expected filters to differ after adding an item to one")
+	}
+
+	if a.Equal(New(10000, WithErrorRate(0.01))) {
+		t.Error("expected filters of different geometry to differ")
+	}
+
+	if !NewLazy(1000).Equal(New(1000)) {
+		t.Error("expected an unallocated filter to equal an empty one")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	t.Parallel()
+
+	filters := []interface {
+		Adder
+		Checker
+		Counter
+	}{
+		New(1000),
+		NewScalable(100),
+		NewCounting(1000),
+		NewConcurrent(1000),
+	}
+
+	for _, bf := range filters {
+		for _, w := range web2[:500] {
+			bf.Add([]byte(w))
+		}
+
+		if bf.Count() != 500 {
+			t.Errorf("%T: expected count 500, got %d", bf, bf.Count())
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	h := fnv.New64()
+	bf := New(1000, WithHash(h))
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	if err := bf.Resize(100000, WithErrorRate(0.01)); err != nil {
+		t.Fatal(err)
+	}
+
+	if bf.n != 100000 || bf.m != M(100000, 0.5, 0.01) || bf.k != K(0.01) || len(bf.b) != int(bf.k) {
+		t.Fatalf("unexpected geometry n = %d, m = %d, k = %d", bf.n, bf.m, bf.k)
+	}
+
+	if bf.Count() != 0 || bf.Check([]byte(web2[0])) {
+		t.Error("expected Resize to empty the filter")
+	}
+
+	if bf.h != h {
+		t.Error("expected Resize to keep the hasher")
+	}
+
+	for _, w := range web2[:100000] {
+		bf.Add([]byte(w))
+	}
+	for _, w := range web2[:100000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be found", w)
+		}
+	}
+
+	if err := bf.Resize(0); err == nil || bf.n != 100000 {
+		t.Errorf("expected an error resizing to 0, leaving the filter unchanged, got %v", err)
+	}
+}
+
+func TestConstructorRanges(t *testing.T) {
+	t.Parallel()
+
+	for name, fn := range map[string]func(){
+		"New":          func() { New(1000, WithErrorRate(2.0)) },
+		"NewLazy":      func() { NewLazy(1000, WithFillRatio(1)) },
+		"NewScalable":  func() { NewScalable(1000, WithErrorRate(2.0)) },
+		"NewCounting":  func() { NewCounting(1000, WithFillRatio(2.0)) },
+		"NewTombstone": func() { NewTombstone(1000, WithErrorRate(2.0)) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic for an out of range option", name)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	// Encodings with out of range rates are rejected.
+	data, err := New(1000).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint64(data[1+5*8:], math.Float64bits(2.0))
+	if err := new(Filter).UnmarshalBinary(data); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding for an error rate of 2, got %v", err)
+	}
+}
+
+func TestWithHashSeed(t *testing.T) {
+	t.Parallel()
+
+	build := func(seed uint64) *Filter {
+		bf := New(10000, WithHashFunc(func() hash.Hash { return fnv.New64() }), WithHashSeed(seed))
+		for _, w := range web2[:10000] {
+			bf.AddString(w)
+		}
+		return bf
+	}
+
+	a, b, c := build(42), build(42), build(43)
+	if !a.Equal(b) {
+		t.Error("expected filters with the same seed to be equal")
+	}
+	if a.Equal(c) {
+		t.Error("expected filters with different seeds to differ")
+	}
+
+	for _, w := range web2[:10000] {
+		if !a.Check([]byte(w)) || !a.CheckString(w) {
+			t.Fatalf("expected %q to be found", w)
+		}
+	}
+}
+
+func TestSizing(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		e float64
+		k uint
+	}{{0.1, 4}, {0.01, 7}, {0.001, 10}, {1e-6, 20}} {
+		if k := K(tc.e); k != tc.k {
+			t.Errorf("expected K(%g) == %d, got %d", tc.e, tc.k, k)
+		}
+	}
+
+	if m := M(1000, 0.5, 0.01); m != 9586 {
+		t.Errorf("expected M(1000, 0.5, 0.01) == 9586, got %d", m)
+	}
+
+	if s := S(9586, 7); s != 1370 {
+		t.Errorf("expected S(9586, 7) == 1370, got %d", s)
+	}
+
+	bf := New(1000, WithErrorRate(0.01))
+	if bf.k != K(0.01) || bf.m != M(1000, 0.5, 0.01) || bf.s != S(bf.m, bf.k) {
+		t.Errorf("expected the filter's size to match K, M and S, got k=%d m=%d s=%d", bf.k, bf.m, bf.s)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	item := []byte(web2[0])
+
+	pos := bf.Positions(item)
+	if uint(len(pos)) != bf.k {
+		t.Fatalf("expected %d positions, got %d", bf.k, len(pos))
+	}
+
+	for i, v := range pos {
+		if v >= bf.s {
+			t.Errorf("expected position %d to be less than %d, got %d", i, bf.s, v)
+		}
+	}
+
+	for i, v := range bf.Positions(item) {
+		if v != pos[i] {
+			t.Fatalf("expected the same positions across calls, got %d and %d in partition %d", pos[i], v, i)
+		}
+	}
+
+	if bf.Count() != 0 || !bf.IsEmpty() {
+		t.Error("expected Positions not to modify the filter")
+	}
+
+	bf.Add(item)
+	for i, v := range pos {
+		if !bf.b[i].Test(v) {
+			t.Errorf("expected Add to set bit %d of partition %d", v, i)
+		}
+	}
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	t.Parallel()
+
+	h := &countingHash{Hash: cityhash.New64()}
+	bf := New(uint(len(web2)), WithHash(h))
+
+	added := 0
+	for _, w := range web2 {
+		if bf.AddIfAbsent([]byte(w)) {
+			added++
+		}
+	}
+
+	if h.writes != len(web2) {
+		t.Errorf("expected each item to be hashed once, got %d writes for %d items", h.writes, len(web2))
+	}
+
+	// Only false positives may be reported present.
+	if fp := float64(len(web2)-added) / float64(len(web2)); fp > bf.e {
+		t.Errorf("expected at most %g of new items to be reported present, got %g", bf.e, fp)
+	}
+
+	if bf.Count() != uint(added) {
+		t.Errorf("expected a count of %d, got %d", added, bf.Count())
+	}
+
+	for _, w := range web2 {
+		if bf.AddIfAbsent([]byte(w)) {
+			t.Fatalf("expected %q not to be added twice", w)
+		}
+	}
+
+	if bf.Count() != uint(added) {
+		t.Errorf("expected repeats not to be counted, got %d", bf.Count())
+	}
+}
+
+func BenchmarkAddIfAbsent(b *testing.B) {
+	bf := New(uint(len(web2)))
+	for i := 0; i < b.N; i++ {
+		bf.AddIfAbsent([]byte(web2[i%len(web2)]))
+	}
+}
+
+func BenchmarkCheckThenAdd(b *testing.B) {
+	bf := New(uint(len(web2)))
+	for i := 0; i < b.N; i++ {
+		if item := []byte(web2[i%len(web2)]); !bf.Check(item) {
+			bf.Add(item)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bloomvar publishes bloom filter statistics through package expvar.
+// It is kept apart from package bloom since importing expvar registers a
+// handler on http.DefaultServeMux.
+package bloomvar
+
+import (
+	"expvar"
+
+	"github.com/blocknative/bloom"
+)
+
+// PublishExpvar publishes the Stats of f as the expvar variable name, encoded
+// as JSON.  The statistics are read whenever the variable is, which is not
+// synchronized with Add; use bloom.WithFillCounter to read the fill ratio
+// safely while items are being added.  Like expvar.Publish, it panics if name
+// is already registered.
+func PublishExpvar(name string, f *bloom.Filter) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return f.Stats()
+	}))
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloomvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+
+	"github.com/blocknative/bloom"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	f := bloom.New(1000)
+	PublishExpvar("filter", f)
+
+	for i := 0; i < 500; i++ {
+		f.Add([]byte(fmt.Sprintf("item-%d", i)))
+	}
+
+	var stats bloom.Stats
+	if err := json.Unmarshal([]byte(expvar.Get("filter").String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.FillRatio != f.FillRatio() || stats.FillRatio == 0 {
+		t.Errorf("expected published fill ratio %f, got %f", f.FillRatio(), stats.FillRatio)
+	}
+
+	if stats.Count != 500 {
+		t.Errorf("expected published count 500, got %d", stats.Count)
+	}
+}
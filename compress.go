@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// compressed is the first byte of the encoding produced by
+// MarshalBinaryCompressed, distinguishing it from the version byte that
+// starts the encoding produced by MarshalBinary.
+const compressed = 0x80
+
+// MarshalBinaryCompressed is like MarshalBinary, but compresses the encoding
+// with gzip, following a flag byte; apart from that byte, it is the
+// FormatGzip encoding of MarshalOptimal.  Sparse filters, such as those far
+// below their capacity, compress to a fraction of their plain encoding, about
+// half at a fill ratio of 0.1 and a quarter at 0.03, while full filters gain
+// nothing: at a fill ratio of x, the set bits being random, no encoding beats
+// -x*log2(x)-(1-x)*log2(1-x) bits per bit.  UnmarshalBinary and LoadLenient
+// detect the flag and decompress the encoding transparently.
+func (f *Filter) MarshalBinaryCompressed() ([]byte, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalGzip(compressed, data)
+}
+
+// marshalGzip returns flag, FormatGzip or compressed, followed by the
+// gzip-compressed binary encoding dense.
+func marshalGzip(flag byte, dense []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte(flag)
+
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(dense); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalGzip decompresses the gzip-compressed binary encoding in data.
+// The header is read first, so that no more than the size it implies, of a
+// filter of at most limit bits, or DefaultMaxBits if limit is 0, is
+// decompressed.
+func unmarshalGzip(data []byte, limit uint64) ([]byte, error) {
+	br := bytes.NewReader(data)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, ErrInvalidEncoding
+	}
+	zr.Multistream(false)
+
+	var buf bytes.Buffer
+	h, err := readHeader(io.TeeReader(zr, &buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if limit == 0 {
+		limit = DefaultMaxBits
+	}
+	if h.s > limit/h.k {
+		return nil, ErrInvalidEncoding
+	}
+
+	if _, err := io.CopyN(&buf, zr, int64(h.size()-headerSize)); err != nil {
+		return nil, ErrInvalidEncoding
+	}
+
+	// The compressed stream must end with the encoding, and the data with the
+	// compressed stream.
+	if n, err := zr.Read(make([]byte, 1)); n != 0 || err != io.EOF || br.Len() != 0 {
+		return nil, ErrInvalidEncoding
+	}
+
+	return buf.Bytes(), nil
+}
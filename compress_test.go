@@ -0,0 +1,81 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalBinaryCompressed(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		items int
+		ratio float64
+	}{
+		{0, 0.01},
+		{500, 0.3},    // a fill ratio of about 0.03
+		{1500, 0.5},   // about 0.1
+		{10000, 1.01}, // at capacity, 0.5
+	} {
+		bf := New(10000)
+		for _, w := range web2[:tc.items] {
+			bf.Add([]byte(w))
+		}
+
+		raw, err := bf.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := bf.MarshalBinaryCompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r := float64(len(data)) / float64(len(raw)); r > tc.ratio {
+			t.Errorf("expected %d items to compress to at most %g of %d bytes, got %g", tc.items, tc.ratio, len(raw), r)
+		}
+
+		var got Filter
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bf.Equal(&got) || got.Count() != bf.Count() {
+			t.Errorf("expected the filter of %d items to round-trip", tc.items)
+		}
+	}
+
+	bf := New(1000)
+	data, err := bf.MarshalBinaryCompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apart from its flag byte, the encoding is MarshalOptimal's FormatGzip.
+	dense, _ := bf.MarshalBinary()
+	if gz, _ := marshalGzip(FormatGzip, dense); !bytes.Equal(gz[1:], data[1:]) {
+		t.Error("expected the compressed encoding to match FormatGzip")
+	}
+
+	var got Filter
+	for _, b := range [][]byte{data[:len(data)/2], append(data[:len(data):len(data)], 0), {compressed}} {
+		if err := got.UnmarshalBinary(b); err == nil {
+			t.Errorf("expected an error for malformed compressed data %x", b)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "sync"
+
+// ConcurrentFilter is a Filter that is safe for concurrent use by multiple
+// goroutines.  Adds are exclusive, while checks share a read lock, and only
+// serialize hashing if the filter was given a single hasher with WithHash.
+type ConcurrentFilter struct {
+	mu sync.RWMutex
+	f  *Filter
+
+	// hmu guards the filter's hasher when checks share it.
+	hmu sync.Mutex
+}
+
+// NewConcurrent returns a concurrent filter predicted to hold n items.  See
+// New.
+func NewConcurrent(n uint, opt ...Option) *ConcurrentFilter {
+	return &ConcurrentFilter{f: New(n, opt...)}
+}
+
+// Add adds item to the filter.
+func (cf *ConcurrentFilter) Add(item []byte) {
+	cf.mu.Lock()
+	cf.f.Add(item)
+	cf.mu.Unlock()
+}
+
+// Check returns true if item may be in the filter, and false if it
+// definitely is not.
+func (cf *ConcurrentFilter) Check(item []byte) bool {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+
+	if cf.f.hashers == nil {
+		cf.hmu.Lock()
+		defer cf.hmu.Unlock()
+	}
+
+	return cf.f.Check(item)
+}
+
+// Count returns the number of items added to the filter.
+func (cf *ConcurrentFilter) Count() uint {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	return cf.f.Count()
+}
+
+// FillRatio returns the average fill ratio of the filter's partitions.
+func (cf *ConcurrentFilter) FillRatio() float64 {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	return cf.f.FillRatio()
+}
+
+// Reset empties the filter.
+func (cf *ConcurrentFilter) Reset() {
+	cf.mu.Lock()
+	cf.f.Reset()
+	cf.mu.Unlock()
+}
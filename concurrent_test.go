@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"hash"
+	"hash/fnv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentFilter(t *testing.T) {
+	t.Parallel()
+
+	const workers, per = 100, 100
+
+	cf := NewConcurrent(workers * per)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(words []string) {
+			defer wg.Done()
+
+			for i, word := range words {
+				cf.Add([]byte(word))
+
+				// Every word added so far must be found.
+				for _, prev := range words[:i+1] {
+					if !cf.Check([]byte(prev)) {
+						t.Errorf("expected %q to be found", prev)
+						return
+					}
+				}
+			}
+		}(web2[w*per : (w+1)*per])
+	}
+	wg.Wait()
+
+	if cf.Count() != workers*per {
+		t.Errorf("expected count %d, got %d", workers*per, cf.Count())
+	}
+
+	if cf.FillRatio() == 0 {
+		t.Error("expected a non-zero fill ratio")
+	}
+
+	cf.Reset()
+	if cf.Count() != 0 || cf.Check([]byte(web2[0])) {
+		t.Error("expected an empty filter after Reset")
+	}
+}
+
+func TestFilterConcurrentCheck(t *testing.T) {
+	t.Parallel()
+
+	for name, bf := range map[string]*Filter{
+		"default":  New(10000),
+		"hashFunc": New(10000, WithHashFunc(func() hash.Hash { return fnv.New64() })),
+	} {
+		for _, w := range web2[:10000] {
+			bf.Add([]byte(w))
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for _, w := range web2[:10000] {
+					if !bf.Check([]byte(w)) {
+						t.Errorf("%s: expected %q to be found", name, w)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
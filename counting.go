@@ -0,0 +1,198 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "fmt"
+
+// defaultCounterBits is the width of each counter of a CountingFilter,
+// unless set with WithCounterBits.
+const defaultCounterBits = 4
+
+// CountingFilter is a partitioned bloom filter whose partitions hold small
+// counters rather than bits, so that it can tell roughly how many times an
+// item was added.  Each counter saturates at its maximum value, after which
+// it no longer changes.
+type CountingFilter struct {
+	// f holds the geometry, hasher and bit locations; its bit partitions
+	// are not allocated.
+	f *Filter
+
+	// w is the width of each counter in bits, which divides 64 so that no
+	// counter straddles two words.
+	w uint
+
+	// cs holds the counters of each of the k partitions, packed into words.
+	cs [][]uint64
+
+	// saturated records whether a counter has reached its maximum.
+	saturated bool
+}
+
+// NewCounting initializes a new counting filter.
+// n is the number of items the filter is predicted to hold.
+//
+// The options sizing and hashing a Filter apply, as does WithCounterBits; it
+// panics on options that only a Filter or ScalableFilter implements, such as
+// WithWAL, WithFPRCallback, WithFillCounter and WithGuard.
+func NewCounting(n uint, opt ...Option) *CountingFilter {
+	f, err := newFilter(n, opt)
+	if err != nil {
+		panic(err)
+	}
+
+	if name := f.unsupported(); name != "" {
+		panic(name + " is not supported by a CountingFilter")
+	}
+
+	w := f.counterBits
+	switch w {
+	case 0:
+		w = defaultCounterBits
+	case 4, 8, 16:
+	default:
+		panic(fmt.Sprintf("unsupported counter width %d", w))
+	}
+
+	cf := &CountingFilter{f: f, w: uint(w)}
+	cf.cs = make([][]uint64, f.k)
+	for i := range cf.cs {
+		cf.cs[i] = make([]uint64, (f.s*cf.w+63)/64)
+	}
+
+	return cf
+}
+
+func (cf *CountingFilter) Add(item []byte) {
+	cf.AddWeighted(item, 1)
+}
+
+// AddWeighted adds item weight times, as if Add were called weight times.
+// Counters that would exceed their maximum saturate at it.  It panics if
+// weight is negative.
+func (cf *CountingFilter) AddWeighted(item []byte, weight int) {
+	if weight < 0 {
+		panic("weight < 0")
+	}
+
+	cf.f.bits(item)
+	for i, v := range cf.f.bs[:cf.f.k] {
+		c := cf.get(i, v)
+		if uint64(weight) > cf.max()-c {
+			c = cf.max()
+		} else {
+			c += uint64(weight)
+		}
+		if c == cf.max() && weight > 0 {
+			cf.saturated = true
+		}
+		cf.put(i, v, c)
+	}
+	cf.f.c += uint(weight)
+}
+
+// Remove removes one occurrence of item by decrementing its counters.
+// Saturated counters are left at their maximum, since the number of items
+// sharing them is no longer known, and Count is only decremented if at
+// least one counter was.  If item is definitely absent, Remove does nothing.
+//
+// WARNING: removing an item that was never added, but which the filter
+// reports present as a false positive, decrements counters belonging to
+// other items, which may then be reported absent: only remove items known to
+// have been added.
+func (cf *CountingFilter) Remove(item []byte) {
+	cf.f.bits(item)
+	for i, v := range cf.f.bs[:cf.f.k] {
+		if cf.get(i, v) == 0 {
+			return
+		}
+	}
+
+	removed := false
+	for i, v := range cf.f.bs[:cf.f.k] {
+		if c := cf.get(i, v); c != cf.max() {
+			cf.put(i, v, c-1)
+			removed = true
+		}
+	}
+
+	// Removing false positives may decrement counters more often than items
+	// were added, so the count is kept from wrapping around as well.
+	if removed && cf.f.c > 0 {
+		cf.f.c--
+	}
+}
+
+func (cf *CountingFilter) Check(item []byte) bool {
+	return cf.CheckAtLeast(item, 1)
+}
+
+// CheckAtLeast reports whether item may have been added at least threshold
+// times.  Like Check, it may report false positives, and it may overstate
+// an item's count when other items share its counters, but never
+// understates it unless its counters were saturated.
+func (cf *CountingFilter) CheckAtLeast(item []byte, threshold int) bool {
+	if threshold <= 0 {
+		return true
+	}
+
+	cf.f.bits(item)
+	for i, v := range cf.f.bs[:cf.f.k] {
+		if cf.get(i, v) < uint64(threshold) {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the total weight of the items added.
+func (cf *CountingFilter) Count() uint {
+	return cf.f.c
+}
+
+func (cf *CountingFilter) Reset() {
+	for _, p := range cf.cs {
+		for i := range p {
+			p[i] = 0
+		}
+	}
+	cf.f.c = 0
+	cf.saturated = false
+}
+
+// Saturated reports whether any counter has reached its maximum value since
+// the filter was created or Reset, in which case the counts of the items
+// sharing it may be understated.  Wider counters, set with WithCounterBits,
+// saturate later.
+func (cf *CountingFilter) Saturated() bool {
+	return cf.saturated
+}
+
+// max returns the value at which a counter saturates.
+func (cf *CountingFilter) max() uint64 {
+	return 1<<cf.w - 1
+}
+
+// get returns counter j of partition i.
+func (cf *CountingFilter) get(i int, j uint) uint64 {
+	o := j * cf.w
+	return cf.cs[i][o/64] >> (o % 64) & cf.max()
+}
+
+// put sets counter j of partition i to c, which must not exceed max.
+func (cf *CountingFilter) put(i int, j uint, c uint64) {
+	o := j * cf.w
+	w := &cf.cs[i][o/64]
+	*w = *w&^(cf.max()<<(o%64)) | c<<(o%64)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCountingFilter(t *testing.T) {
+	t.Parallel()
+
+	cf := NewCounting(uint(len(web2)))
+	testBloomFilter(t, cf)
+}
+
+func TestCountingAddWeighted(t *testing.T) {
+	t.Parallel()
+
+	cf := NewCounting(1000)
+	for _, w := range web2[:500] {
+		cf.Add([]byte(w))
+	}
+
+	item := []byte("rate-limited")
+	cf.AddWeighted(item, 5)
+
+	if !cf.CheckAtLeast(item, 5) {
+		t.Error("expected an item added with weight 5 to be seen at least 5 times")
+	}
+
+	if cf.CheckAtLeast(item, 6) {
+		t.Error("expected an item added with weight 5 not to be seen 6 times")
+	}
+
+	if cf.Count() != 505 {
+		t.Errorf("expected count 505, got %d", cf.Count())
+	}
+
+	// Counters saturate rather than wrap around.
+	cf.AddWeighted(item, 100)
+	if !cf.CheckAtLeast(item, int(cf.max())) || cf.CheckAtLeast(item, int(cf.max())+1) {
+		t.Errorf("expected the counters to saturate at %d", cf.max())
+	}
+}
+
+func TestCountingCounterBits(t *testing.T) {
+	t.Parallel()
+
+	item := []byte("hot")
+	for _, tc := range []struct {
+		bits, adds, want int
+		saturated        bool
+	}{
+		{4, 20, 15, true},
+		{8, 20, 20, false},
+		{16, 1000, 1000, false},
+	} {
+		cf := NewCounting(1000, WithCounterBits(tc.bits))
+		for i := 0; i < tc.adds; i++ {
+			cf.Add(item)
+		}
+
+		if !cf.CheckAtLeast(item, tc.want) || cf.CheckAtLeast(item, tc.want+1) {
+			t.Errorf("%d-bit counters: expected a count of %d", tc.bits, tc.want)
+		}
+
+		if cf.Saturated() != tc.saturated {
+			t.Errorf("%d-bit counters: expected saturated %v", tc.bits, tc.saturated)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an unsupported width to panic")
+		}
+	}()
+	NewCounting(1000, WithCounterBits(5))
+}
+
+func TestCountingRemove(t *testing.T) {
+	t.Parallel()
+
+	cf := NewCounting(10000)
+	for _, w := range web2[:10000] {
+		cf.Add([]byte(w))
+	}
+
+	for cycle := 0; cycle < 3; cycle++ {
+		for _, w := range web2[:5000] {
+			cf.Remove([]byte(w))
+		}
+
+		if cf.Count() != 5000 {
+			t.Fatalf("cycle %d: expected count 5000, got %d", cycle, cf.Count())
+		}
+
+		for _, w := range web2[5000:10000] {
+			if !cf.Check([]byte(w)) {
+				t.Fatalf("cycle %d: expected %q to survive removing other items", cycle, w)
+			}
+		}
+
+		var fp int
+		for _, w := range web2[:5000] {
+			if cf.Check([]byte(w)) {
+				fp++
+			}
+		}
+		if fp > 50 {
+			t.Fatalf("cycle %d: expected removed items to be absent, %d still found", cycle, fp)
+		}
+
+		for _, w := range web2[:5000] {
+			cf.Add([]byte(w))
+		}
+
+		for _, w := range web2[:10000] {
+			if !cf.Check([]byte(w)) {
+				t.Fatalf("cycle %d: expected %q to be found after adding it again", cycle, w)
+			}
+		}
+	}
+
+	// Removing a definitely absent item changes nothing.
+	before := cf.Count()
+	cf.Remove([]byte("never added"))
+	if cf.Count() != before {
+		t.Errorf("expected removing an absent item to keep count %d, got %d", before, cf.Count())
+	}
+
+	// Saturated counters are never decremented.
+	item := []byte("hot")
+	cf.AddWeighted(item, 100)
+	for i := 0; i < 100; i++ {
+		cf.Remove(item)
+	}
+	if !cf.Check(item) {
+		t.Error("expected an item with saturated counters to survive removal")
+	}
+
+	// Removing an item whose counters are all saturated leaves the count
+	// alone rather than wrapping it around.
+	cf = NewCounting(1000, WithCounterBits(4))
+	cf.AddWeighted(item, 15)
+	for i := 0; i < 16; i++ {
+		cf.Remove(item)
+	}
+	if cf.Count() != 15 {
+		t.Errorf("expected count 15 after removing a saturated item, got %d", cf.Count())
+	}
+}
+
+func TestCountingUnsupportedOptions(t *testing.T) {
+	t.Parallel()
+
+	for name, opt := range map[string]Option{
+		"WithWAL":         WithWAL(io.Discard),
+		"WithFillCounter": WithFillCounter(),
+		"WithGuard":       WithGuard(1000),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %s to panic", name)
+				}
+			}()
+			NewCounting(1000, opt)
+		}()
+	}
+
+	// Options hashing items apply.
+	NewCounting(1000, WithKeyTransform(bytes.ToLower), WithHashSeed(1))
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dleft implements a d-left counting bloom filter.
+//
+// Reference: An Improved Construction for Counting Bloom Filters
+// (Bonomi, Mitzenmacher, Panigrahy, Singh, Varghese)
+//
+// Rather than one counter per bit, each item is reduced to a fingerprint
+// that is stored, together with a small counter, in the least loaded of d
+// candidate buckets.  Each candidate location is obtained by applying an
+// invertible permutation to the item's true fingerprint, so two items share
+// a stored cell only if they share the same true fingerprint.  This keeps
+// deletes reliable: removing an item can never decrement another item's
+// counter.
+package dleft
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math"
+	"math/bits"
+
+	"github.com/zentures/cityhash"
+)
+
+const (
+	// d is the number of subtables, and therefore of candidate buckets
+	// for each item.
+	d = 4
+
+	// width is the number of cells in each bucket.
+	width = 8
+
+	// load is the target fraction of occupied cells at capacity.
+	load = 0.75
+
+	// maxCount is the value at which a cell's counter saturates.
+	maxCount = math.MaxUint8
+)
+
+var (
+	// ErrFull is returned by Add when all candidate buckets for an item
+	// are full.
+	ErrFull = errors.New("dleft: bucket overflow")
+
+	// ErrSaturated is returned by Add when the item's counter has reached
+	// its maximum value.
+	ErrSaturated = errors.New("dleft: counter saturated")
+)
+
+// multipliers and offsets define the per-subtable permutations of the
+// fingerprint space.  Multipliers must be odd.
+var (
+	multipliers = [d]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+	offsets     = [d]uint64{0x2545f4914f6cdd1d, 0x632be59bd9b4e019, 0x85ebca77c2b2ae63, 0xc2b2ae3d27d4eb4f}
+)
+
+type cell struct {
+	// fp is the fingerprint remainder stored in the cell.
+	fp uint32
+
+	// count is the number of times the fingerprint was added.  A count of
+	// zero marks an empty cell.
+	count uint8
+}
+
+// Filter is a d-left counting bloom filter.
+type Filter struct {
+	h hash.Hash
+
+	// e is the desired error rate for the filter.
+	e float64
+
+	// n is the number of elements the filter is predicted to hold.
+	n uint
+
+	// c is the number of items held by the filter, counting duplicates.
+	c uint
+
+	// bb is log2 of the number of buckets in each subtable.
+	bb uint
+
+	// r is the number of bits in each stored fingerprint remainder.
+	r uint
+
+	// t holds the d subtables.  Bucket j of a subtable occupies cells
+	// [j*width, (j+1)*width).
+	t [d][]cell
+}
+
+// Option configures a Filter.
+type Option func(*Filter)
+
+// WithHash specifies the hash to use with the filter.  The hash must produce
+// at least 8 bytes.  If h == nil, defaults to CityHash.
+func WithHash(h hash.Hash) Option {
+	if h == nil {
+		h = cityhash.New64()
+	}
+
+	return func(f *Filter) {
+		f.h = h
+	}
+}
+
+// WithErrorRate sets the desired error rate for the filter.  Smaller values of
+// e imply longer stored fingerprints.
+//
+// If e <= 0, defaults to .001.
+func WithErrorRate(e float64) Option {
+	if e <= 0 {
+		e = .001
+	}
+
+	return func(f *Filter) {
+		f.e = e
+	}
+}
+
+// New initializes a new d-left counting bloom filter.
+// n is the number of items the filter is predicted to hold.
+func New(n uint, opt ...Option) *Filter {
+	if n == 0 {
+		panic("n == 0")
+	}
+
+	f := Filter{n: n}
+	for _, option := range append([]Option{WithHash(nil), WithErrorRate(0)}, opt...) {
+		option(&f)
+	}
+
+	// A lookup compares against at most d*width stored remainders, of which
+	// a fraction load is occupied at capacity, each matching with probability
+	// 2^-r.  Choose r so the expected number of matches is below e.
+	f.r = uint(math.Ceil(math.Log2(d * width * load / f.e)))
+	if f.r > 32 {
+		f.r = 32
+	}
+
+	buckets := uint(math.Ceil(float64(n) / (d * width * load)))
+	f.bb = uint(bits.Len(buckets - 1))
+	if f.bb+f.r > 64 {
+		panic("filter too large")
+	}
+
+	for i := range f.t {
+		f.t[i] = make([]cell, width<<f.bb)
+	}
+
+	return &f
+}
+
+// Add inserts item into the filter.  It returns ErrFull if all of the item's
+// candidate buckets are full, and ErrSaturated if the item's counter cannot
+// be incremented further.  The filter is unchanged when an error is returned.
+func (f *Filter) Add(item []byte) error {
+	fp := f.fingerprint(item)
+
+	if c := f.find(fp); c != nil {
+		if c.count == maxCount {
+			return ErrSaturated
+		}
+		c.count++
+		f.c++
+		return nil
+	}
+
+	// Place the fingerprint in the least loaded candidate bucket, breaking
+	// ties to the left.
+	var (
+		best     []cell
+		bestLoad = width
+		rem      uint32
+	)
+
+	for i := range f.t {
+		bucket, r := f.locate(i, fp)
+
+		l := 0
+		for _, c := range bucket {
+			if c.count != 0 {
+				l++
+			}
+		}
+
+		if l < bestLoad {
+			best, bestLoad, rem = bucket, l, r
+		}
+	}
+
+	if best == nil {
+		return ErrFull
+	}
+
+	for i := range best {
+		if best[i].count == 0 {
+			best[i] = cell{fp: rem, count: 1}
+			break
+		}
+	}
+
+	f.c++
+	return nil
+}
+
+// Check returns true if item may be in the filter.
+func (f *Filter) Check(item []byte) bool {
+	return f.find(f.fingerprint(item)) != nil
+}
+
+// Delete removes one occurrence of item from the filter, returning false if
+// the item was not found.  Deleting an item that was never added succeeds
+// only when it is a false positive, in which case it removes an occurrence
+// of the item sharing its fingerprint.
+func (f *Filter) Delete(item []byte) bool {
+	c := f.find(f.fingerprint(item))
+	if c == nil {
+		return false
+	}
+
+	// A saturated counter no longer knows how many occurrences it holds,
+	// so it is never decremented, and neither is the count, which stays the
+	// sum of the counters.
+	if c.count != maxCount {
+		c.count--
+		f.c--
+	}
+	return true
+}
+
+// Count returns the number of items held by the filter, counting duplicates.
+func (f *Filter) Count() uint {
+	return f.c
+}
+
+// fingerprint returns the item's true fingerprint, of f.bb+f.r bits.
+func (f *Filter) fingerprint(item []byte) uint64 {
+	f.h.Reset()
+	f.h.Write(item)
+	return binary.BigEndian.Uint64(f.h.Sum(nil)) & mask(f.bb+f.r)
+}
+
+// locate returns the candidate bucket in subtable i for the true fingerprint
+// fp, along with the remainder to store in it.
+func (f *Filter) locate(i int, fp uint64) ([]cell, uint32) {
+	p := (fp*multipliers[i] + offsets[i]) & mask(f.bb+f.r)
+	j := p >> f.r
+	return f.t[i][j*width : (j+1)*width], uint32(p & mask(f.r))
+}
+
+// find returns the cell holding fp, or nil if it is not stored.
+func (f *Filter) find(fp uint64) *cell {
+	for i := range f.t {
+		bucket, rem := f.locate(i, fp)
+		for j := range bucket {
+			if bucket[j].count != 0 && bucket[j].fp == rem {
+				return &bucket[j]
+			}
+		}
+	}
+	return nil
+}
+
+func mask(n uint) uint64 {
+	if n >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<n - 1
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dleft
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/blocknative/bloom"
+)
+
+// readLines returns the lines of the file at path or, if it does not exist, the
+// n bloom.SyntheticKeys of seed, so that the tests measure false positives
+// meaningfully even without the dictionaries.
+func readLines(t *testing.T, path string, n int, seed uint64) []string {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		lines := make([]string, n)
+		for i, key := range bloom.SyntheticKeys(n, seed) {
+			lines[i] = string(key)
+		}
+		return lines
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	web2 := readLines(t, "../testdata/web2.golden", 235886, 1)
+	web2a := readLines(t, "../testdata/web2a.golden", 76205, 2)
+
+	f := New(uint(len(web2)))
+	for _, w := range web2 {
+		if err := f.Add([]byte(w)); err != nil {
+			t.Fatalf("add %q: %v", w, err)
+		}
+	}
+
+	for _, w := range web2 {
+		if !f.Check([]byte(w)) {
+			t.Fatalf("false negative for %q", w)
+		}
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if f.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / float64(len(web2a)); rate > f.e {
+		t.Errorf("false-positive rate %.4f exceeds %.4f", rate, f.e)
+	}
+
+	// Delete the first half and make sure the second half survives.
+	half := len(web2) / 2
+	for _, w := range web2[:half] {
+		if !f.Delete([]byte(w)) {
+			t.Fatalf("delete %q: not found", w)
+		}
+	}
+
+	for _, w := range web2[half:] {
+		if !f.Check([]byte(w)) {
+			t.Fatalf("false negative for %q after deletes", w)
+		}
+	}
+
+	present := 0
+	for _, w := range web2[:half] {
+		if f.Check([]byte(w)) {
+			present++
+		}
+	}
+
+	if rate := float64(present) / float64(half); rate > f.e {
+		t.Errorf("%.4f of deleted items still present", rate)
+	}
+
+	if f.Count() != uint(len(web2)-half) {
+		t.Errorf("expected count %d, got %d", len(web2)-half, f.Count())
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	t.Parallel()
+
+	f := New(100)
+	item := []byte("duplicate")
+
+	f.Add(item)
+	f.Add(item)
+
+	if !f.Delete(item) || !f.Check(item) {
+		t.Fatal("expected item to remain after deleting one of two copies")
+	}
+
+	if !f.Delete(item) || f.Check(item) {
+		t.Fatal("expected item to be absent after deleting both copies")
+	}
+
+	if f.Delete(item) {
+		t.Error("expected delete of an absent item to fail")
+	}
+
+	// Deleting a saturated item leaves both its counter and the count alone.
+	for f.Add(item) == nil {
+	}
+	for i := 0; i <= maxCount; i++ {
+		f.Delete(item)
+	}
+	if f.Count() != maxCount || !f.Check(item) {
+		t.Errorf("expected a saturated item to keep count %d, got %d", maxCount, f.Count())
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	t.Parallel()
+
+	f := New(1)
+
+	var err error
+	added := 0
+	for i := 0; i < 1000 && err == nil; i++ {
+		if err = f.Add([]byte(fmt.Sprintf("item-%d", i))); err == nil {
+			added++
+		}
+	}
+
+	if err != ErrFull {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+
+	if added > d*width {
+		t.Errorf("added %d items to a filter with %d cells", added, d*width)
+	}
+
+	if f.Count() != uint(added) {
+		t.Errorf("expected count %d, got %d", added, f.Count())
+	}
+}
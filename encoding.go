@@ -0,0 +1,748 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+// version is the current version of the binary encoding.  Version 1 encoded
+// partition words big-endian; version 2 encodes them little-endian, so that
+// the partitions are serialized as the bytes of their bit arrays; version 3
+// follows each partition with its checksum.  All are decoded.
+const version = 3
+
+// castagnoli is the table of the CRC-32C partition checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptPartitionsError is returned when partitions fail their checksum on
+// decoding.
+type CorruptPartitionsError struct {
+	// Partitions lists the indices of the corrupt partitions.
+	Partitions []int
+}
+
+func (e *CorruptPartitionsError) Error() string {
+	return fmt.Sprintf("bloom: corrupt partitions %v", e.Partitions)
+}
+
+// ErrInvalidEncoding is returned when decoding malformed filter data.
+var ErrInvalidEncoding = errors.New("bloom: invalid encoding")
+
+// nativeLittleEndian reports whether the host stores words little-endian.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// RegisterGob registers the package's gob-encodable types, so that they can
+// be encoded as interface values without registering them manually.
+func RegisterGob() {
+	gob.Register(&Filter{})
+	gob.Register(&ScalableFilter{})
+}
+
+// header is the fixed-size prefix of the binary encoding, following the
+// version byte.
+type header struct {
+	v             byte
+	n, m, k, s, c uint64
+	e, p          float64
+}
+
+// headerSize is the size of the version byte and header.
+const headerSize = 1 + 7*8
+
+func (f *Filter) header() header {
+	return header{
+		v: version,
+		n: uint64(f.n),
+		m: uint64(f.m),
+		k: uint64(f.k),
+		s: uint64(f.s),
+		c: uint64(f.c),
+		e: f.e,
+		p: f.p,
+	}
+}
+
+// writeTo writes the current version byte and header to w.
+func (h header) writeTo(w io.Writer) error {
+	var buf [headerSize]byte
+
+	buf[0] = version
+	for i, v := range []uint64{h.n, h.m, h.k, h.s, h.c, math.Float64bits(h.e), math.Float64bits(h.p)} {
+		binary.BigEndian.PutUint64(buf[1+i*8:], v)
+	}
+
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// readHeader reads and validates the version byte and header from r.
+func readHeader(r io.Reader) (header, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return header{}, ErrInvalidEncoding
+	}
+
+	if buf[0] == 0 || buf[0] > version {
+		return header{}, fmt.Errorf("bloom: unsupported encoding version %d", buf[0])
+	}
+
+	var v [7]uint64
+	for i := range v {
+		v[i] = binary.BigEndian.Uint64(buf[1+i*8:])
+	}
+
+	h := header{v: buf[0], n: v[0], m: v[1], k: v[2], s: v[3], c: v[4], e: math.Float64frombits(v[5]), p: math.Float64frombits(v[6])}
+
+	// Bound k and s so that the size of each partition cannot overflow, and
+	// then k by that size so that the size of the encoding cannot either.
+	if h.k == 0 || h.s == 0 || h.k > math.MaxUint32 || h.s > math.MaxUint32<<6 ||
+		h.k > uint64(^uint(0)) || h.s > uint64(^uint(0)) || checkRates(h.e, h.p) != nil {
+		return header{}, ErrInvalidEncoding
+	}
+	if h.k > (math.MaxUint64-headerSize)/h.partitionSize() {
+		return header{}, ErrInvalidEncoding
+	}
+
+	return h, nil
+}
+
+// order returns the byte order of the partition words following h.
+func (h header) order() binary.ByteOrder {
+	if h.v == 1 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Header describes a filter's geometry, as read by ReadHeader from the start
+// of its binary encoding.
+type Header struct {
+	// Version is the version of the encoding.
+	Version byte
+
+	// N is the number of items the filter was sized for, M, K and S the
+	// total number of bits, the number of partitions and the number of bits
+	// per partition, and Count the number of items added.
+	N, M, K, S, Count uint64
+
+	// ErrorRate and FillRatio are the configured e and p.
+	ErrorRate, FillRatio float64
+}
+
+// ReadHeader reads the fixed-size header at the start of a filter's binary
+// encoding, as produced by MarshalBinary, from r, leaving r positioned at the
+// partition data that follows.  The encoding written by WriteTo is preceded
+// by an 8-byte length, which must be skipped first.
+func ReadHeader(r io.Reader) (Header, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		Version:   h.v,
+		N:         h.n,
+		M:         h.m,
+		K:         h.k,
+		S:         h.s,
+		Count:     h.c,
+		ErrorRate: h.e,
+		FillRatio: h.p,
+	}, nil
+}
+
+// words returns the number of 64-bit words in each partition.
+func (h header) words() uint64 {
+	return (h.s + 63) / 64
+}
+
+// checksummed reports whether each partition following h is followed by its
+// checksum.
+func (h header) checksummed() bool {
+	return h.v >= 3
+}
+
+// partitionSize returns the size of the encoding of each partition.
+func (h header) partitionSize() uint64 {
+	if h.checksummed() {
+		return h.words()*8 + 4
+	}
+	return h.words() * 8
+}
+
+// size returns the size of the encoding, excluding any length prefix.
+func (h header) size() uint64 {
+	return headerSize + h.k*h.partitionSize()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.  The hasher is not
+// encoded; see UnmarshalBinary.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	h := f.header()
+
+	data := make([]byte, h.size())
+	buf := bytes.NewBuffer(data[:0])
+	h.writeTo(buf)
+
+	// Each partition is encoded as its words in little-endian byte order,
+	// that is as the bytes of its bit array, so that its length is implied by
+	// s and its encoding is the same on every platform, followed by the
+	// little-endian CRC-32C of those bytes.
+	o := uint64(headerSize)
+	for _, b := range f.partitions() {
+		p := data[o : o+h.words()*8]
+		for j, v := range b.Bytes() {
+			binary.LittleEndian.PutUint64(p[j*8:], v)
+		}
+		binary.LittleEndian.PutUint32(data[o+uint64(len(p)):], crc32.Checksum(p, castagnoli))
+		o += h.partitionSize()
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  Since the hasher is
+// not encoded, the receiver's hasher and options are kept if it has any, and
+// the default hasher is used otherwise.  If partitions fail their checksum,
+// it returns a *CorruptPartitionsError and leaves the receiver unchanged;
+// see LoadLenient to recover the others.  The encoding produced by
+// MarshalBinaryCompressed is also accepted.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	return f.unmarshalBinary(data, false)
+}
+
+// LoadLenient is like UnmarshalBinary, but a partition that fails its
+// checksum is zeroed rather than failing the whole decoding.  The filter is
+// then loaded, and a *CorruptPartitionsError listing the zeroed partitions is
+// returned as a warning.  Items then test absent in every zeroed partition,
+// so that the filter has false negatives: it suits best-effort uses such as
+// caches, where some answers beat none.
+func (f *Filter) LoadLenient(data []byte) error {
+	return f.unmarshalBinary(data, true)
+}
+
+func (f *Filter) unmarshalBinary(data []byte, lenient bool) error {
+	if len(data) > 0 && data[0] == compressed {
+		var err error
+		if data, err = unmarshalGzip(data[1:], f.maxBits); err != nil {
+			return err
+		}
+	}
+
+	r := bytes.NewReader(data)
+
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	// Check k against the data before computing the size from it.
+	if h.k > (uint64(len(data))-headerSize)/h.partitionSize() || uint64(len(data)) != h.size() {
+		return ErrInvalidEncoding
+	}
+
+	g := f.decoded(h)
+
+	var corrupt []int
+
+	g.b = make([]*bitset.BitSet, g.k)
+	for i := range g.b {
+		o := headerSize + uint64(i)*h.partitionSize()
+		p := data[o : o+h.words()*8]
+
+		set := make([]uint64, h.words())
+		if h.checksummed() && crc32.Checksum(p, castagnoli) != binary.LittleEndian.Uint32(data[o+uint64(len(p)):]) {
+			corrupt = append(corrupt, i)
+		} else {
+			for j := range set {
+				set[j] = h.order().Uint64(p[j*8:])
+			}
+		}
+		g.b[i] = bitset.FromWithLength(g.s, set)
+	}
+
+	if corrupt != nil && !lenient {
+		return &CorruptPartitionsError{Partitions: corrupt}
+	}
+
+	g.finishDecode()
+
+	*f = g
+
+	if corrupt != nil {
+		return &CorruptPartitionsError{Partitions: corrupt}
+	}
+	return nil
+}
+
+// decoded returns a filter with the receiver's parameters and the geometry
+// and count of h, without its partitions.
+func (f *Filter) decoded(h header) Filter {
+	g := Filter{
+		params: f.params,
+		n:      uint(h.n),
+		m:      uint(h.m),
+		k:      uint(h.k),
+		s:      uint(h.s),
+		c:      uint(h.c),
+	}
+	g.e = h.e
+	g.p = h.p
+
+	return g
+}
+
+// finishDecode completes a filter whose partitions have been decoded.
+func (f *Filter) finishDecode() {
+	if f.h == nil {
+		WithHash(nil)(&f.params)
+	}
+	f.bs = make([]uint, f.k)
+	f.ones = uint64(f.count())
+}
+
+// NewFromBytes returns a filter sized for n items whose partitions are read
+// from data, which must hold the concatenated partition words in the layout
+// produced by PartitionBytes.  The item count is estimated from the bits set.
+//
+// Where the host is little-endian and data is 8-byte aligned, the filter
+// wraps data without copying it, so that adding items writes through to
+// data.  Such a filter must not be modified if data is read-only, as with a
+// read-only memory mapping.
+func NewFromBytes(n uint, data []byte, opt ...Option) (*Filter, error) {
+	f, err := newFilter(n, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	w := words(f.s)
+	if uint64(len(data)) != uint64(f.k)*uint64(w)*8 {
+		return nil, fmt.Errorf("bloom: expected %d bytes of partition data, got %d", f.k*w*8, len(data))
+	}
+
+	f.b = make([]*bitset.BitSet, f.k)
+	for i := range f.b {
+		f.b[i] = bitset.FromWithLength(f.s, wordsOf(data[uint(i)*w*8:uint(i+1)*w*8]))
+	}
+	f.c = f.EstimatedCount()
+	f.ones = uint64(f.count())
+
+	return f, nil
+}
+
+// FromPartitions returns a filter of k partitions of s bits adopting the
+// partitions in b, as built by another tool, with an error rate of e and a
+// fill ratio of p.  b must follow the layout of a Filter with the default
+// hasher: partition i holds bit (a + b*i) mod s of each item whose Digest is
+// (a, b).  Partitions of exactly s bits are adopted without copying, so that
+// adding items writes through to them; the first s bits of longer ones are
+// copied.  The capacity is derived from k*s, e and p, and the item count is
+// estimated from the bits set.
+func FromPartitions(b []*bitset.BitSet, k, s uint, e, p float64) (*Filter, error) {
+	if err := checkRates(e, p); err != nil {
+		return nil, err
+	}
+
+	if k == 0 || s == 0 {
+		return nil, fmt.Errorf("bloom: invalid geometry k=%d s=%d", k, s)
+	}
+
+	if uint(len(b)) != k {
+		return nil, fmt.Errorf("bloom: expected %d partitions, got %d", k, len(b))
+	}
+
+	f := Filter{k: k, s: s, m: k * s}
+	for _, option := range withDefault(nil) {
+		option(&f.params)
+	}
+	f.e = e
+	f.p = p
+
+	// n =~ m * ( (log(p) * log(1-p)) / abs(log e) )
+	f.n = uint(float64(f.m) * math.Log(p) * math.Log(1-p) / math.Abs(math.Log(e)))
+	if f.n == 0 {
+		f.n = 1
+	}
+
+	f.b = make([]*bitset.BitSet, k)
+	for i, v := range b {
+		switch {
+		case v == nil:
+			return nil, fmt.Errorf("bloom: partition %d is nil", i)
+		case v.Len() < s:
+			return nil, fmt.Errorf("bloom: partition %d has %d bits, expected at least %d", i, v.Len(), s)
+		case v.Len() == s:
+			f.b[i] = v
+		default:
+			set := make([]uint64, words(s))
+			copy(set, v.Bytes())
+			if r := s % 64; r != 0 {
+				set[len(set)-1] &= 1<<r - 1
+			}
+			f.b[i] = bitset.FromWithLength(s, set)
+		}
+	}
+
+	f.bs = make([]uint, f.k)
+	f.c = f.EstimatedCount()
+	f.ones = uint64(f.count())
+
+	return &f, nil
+}
+
+// PartitionBytes returns the filter's partitions in the layout accepted by
+// NewFromBytes: for each partition in turn, its ceil(s/64) 64-bit words in
+// little-endian byte order, bit i of a partition being bit i%64 of word i/64.
+func (f *Filter) PartitionBytes() []byte {
+	w := words(f.s)
+	data := make([]byte, f.k*w*8)
+	for i, b := range f.b {
+		for j, v := range b.Bytes() {
+			binary.LittleEndian.PutUint64(data[(uint(i)*w+uint(j))*8:], v)
+		}
+	}
+	return data
+}
+
+// wordsOf returns the little-endian words held in data, aliasing data when
+// the host layout allows it.
+func wordsOf(data []byte) []uint64 {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if nativeLittleEndian && uintptr(unsafe.Pointer(&data[0]))%8 == 0 {
+		return unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), len(data)/8)
+	}
+
+	set := make([]uint64, len(data)/8)
+	for i := range set {
+		set[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return set
+}
+
+// words returns the number of 64-bit words holding a partition of s bits.
+func words(s uint) uint {
+	return (s + 63) / 64
+}
+
+// WriteTo implements io.WriterTo.  It writes the filter's binary encoding
+// prefixed by its length as a big-endian uint64, so that the filter can be
+// embedded in a stream alongside other data.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], uint64(len(data)))
+
+	n, err := w.Write(l[:])
+	if err != nil {
+		return int64(n), err
+	}
+
+	m, err := w.Write(data)
+	return int64(n + m), err
+}
+
+// ReadFrom implements io.ReaderFrom.  It reads a filter written by WriteTo,
+// consuming exactly the bytes WriteTo wrote and leaving r positioned right
+// after them.
+func (f *Filter) ReadFrom(r io.Reader) (int64, error) {
+	var l [8]byte
+	n, err := io.ReadFull(r, l[:])
+	if err != nil {
+		return int64(n), err
+	}
+
+	// Copy rather than preallocate, so that a corrupt length cannot cause
+	// a huge allocation.
+	size := binary.BigEndian.Uint64(l[:])
+	if size < headerSize || size > math.MaxInt64 {
+		return int64(n), ErrInvalidEncoding
+	}
+
+	var buf bytes.Buffer
+	m, err := io.CopyN(&buf, r, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return int64(n) + m, err
+	}
+
+	return int64(n) + m, f.UnmarshalBinary(buf.Bytes())
+}
+
+// MergeStreams reads filters written by Filter.WriteTo from each of readers,
+// and writes their union to w in the same format.  Only one filter's worth of
+// bits is held in memory at a time, however many readers are merged.  All
+// filters must have the same geometry, of at most DefaultMaxBits bits; the
+// merged count is the sum of their counts.
+func MergeStreams(w io.Writer, readers ...io.Reader) error {
+	if len(readers) == 0 {
+		return errors.New("bloom: no filters to merge")
+	}
+
+	var (
+		acc    []uint64
+		merged header
+		buf    = make([]byte, 4096)
+	)
+
+	for i, r := range readers {
+		var l [8]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return err
+		}
+
+		h, err := readHeader(r)
+		if err != nil {
+			return err
+		}
+
+		size := binary.BigEndian.Uint64(l[:])
+		if size < headerSize || h.k > (size-headerSize)/h.partitionSize() || size != h.size() {
+			return ErrInvalidEncoding
+		}
+
+		// Check the header of every filter before any of its words are read,
+		// and bound the size of the first, from which the accumulator is
+		// sized, by the maximum size of a filter.
+		if i == 0 {
+			if h.s > DefaultMaxBits/h.k {
+				return ErrInvalidEncoding
+			}
+			merged = h
+			merged.v = version
+			acc = make([]uint64, h.k*h.words())
+		} else {
+			if h.k != merged.k || h.s != merged.s || h.m != merged.m {
+				return ErrIncompatible
+			}
+			merged.c += h.c
+		}
+
+		words := int(h.words())
+		for p := 0; p < int(h.k); p++ {
+			var crc uint32
+			for j := p * words; j < (p+1)*words; {
+				chunk := buf
+				if rest := ((p+1)*words - j) * 8; rest < len(chunk) {
+					chunk = chunk[:rest]
+				}
+
+				if _, err = io.ReadFull(r, chunk); err != nil {
+					return ErrInvalidEncoding
+				}
+				crc = crc32.Update(crc, castagnoli, chunk)
+
+				for o := 0; o < len(chunk); o += 8 {
+					acc[j] |= h.order().Uint64(chunk[o:])
+					j++
+				}
+			}
+
+			if h.checksummed() {
+				var sum [4]byte
+				if _, err = io.ReadFull(r, sum[:]); err != nil {
+					return ErrInvalidEncoding
+				}
+				if binary.LittleEndian.Uint32(sum[:]) != crc {
+					return fmt.Errorf("bloom: filter %d: %w", i, &CorruptPartitionsError{Partitions: []int{p}})
+				}
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], merged.size())
+	bw.Write(l[:])
+	merged.writeTo(bw)
+
+	words := int(merged.words())
+	for p := 0; p < int(merged.k); p++ {
+		crc := crc32.New(castagnoli)
+		binary.Write(io.MultiWriter(bw, crc), binary.LittleEndian, acc[p*words:(p+1)*words])
+
+		var sum [4]byte
+		binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+		bw.Write(sum[:])
+	}
+
+	return bw.Flush()
+}
+
+// GobEncode implements gob.GobEncoder.
+func (f *Filter) GobEncode() ([]byte, error) {
+	return f.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder.
+func (f *Filter) GobDecode(data []byte) error {
+	return f.UnmarshalBinary(data)
+}
+
+// scalableState is the gob encoding of a ScalableFilter.  Options are
+// closures that cannot be encoded, so the parameters they resolved to are
+// encoded instead; the hasher is not encoded.
+type scalableState struct {
+	N, C     uint64
+	R        float32
+	E, P     float64
+	MaxBytes uint64
+	Schedule []float64
+
+	// MaxFilters is absent from encodings made before WithMaxFilters, which
+	// decode as unbounded.
+	MaxFilters int64
+
+	// Growth is absent from encodings made before WithGrowthFactor, which
+	// decode as a factor of 1.
+	Growth float64
+
+	// Guard holds the MarshalBinary encoding of the guard, if any.
+	Guard []byte
+
+	// Filters holds the MarshalBinary encoding of each sub-filter.
+	Filters [][]byte
+}
+
+// GobEncode implements gob.GobEncoder.
+func (sbf *ScalableFilter) GobEncode() ([]byte, error) {
+	st := scalableState{
+		N:          uint64(sbf.n),
+		C:          uint64(sbf.c),
+		R:          sbf.r,
+		E:          sbf.e,
+		P:          sbf.p,
+		MaxBytes:   uint64(sbf.maxBytes),
+		Schedule:   sbf.schedule,
+		MaxFilters: int64(sbf.maxFilters),
+		Growth:     sbf.growth,
+		Filters:    make([][]byte, len(sbf.bfs)),
+	}
+
+	for i, bf := range sbf.bfs {
+		data, err := bf.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		st.Filters[i] = data
+	}
+
+	if sbf.guard != nil {
+		data, err := sbf.guard.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		st.Guard = data
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder.  As with Filter.UnmarshalBinary, the
+// receiver's hasher and options are kept if it has any, and the default
+// hasher is used otherwise; the encoded parameters take precedence over the
+// options, so that the filter keeps growing as it did before encoding.
+func (sbf *ScalableFilter) GobDecode(data []byte) error {
+	var st scalableState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&st); err != nil {
+		return err
+	}
+
+	if st.N == 0 || st.N > uint64(^uint(0)) || !(st.R > 0 && st.R < 1) || len(st.Filters) == 0 || checkRates(st.E, st.P) != nil ||
+		st.Growth != 0 && !(st.Growth >= 1) {
+		return ErrInvalidEncoding
+	}
+
+	// Each rate of the schedule is handed to New as the filter grows, which
+	// would panic on one out of range.
+	for _, e := range st.Schedule {
+		if checkRates(e, st.P) != nil {
+			return ErrInvalidEncoding
+		}
+	}
+
+	g := ScalableFilter{
+		params: sbf.params,
+		opt:    copyOptions(sbf.opt),
+		n:      uint(st.N),
+		c:      uint(st.C),
+		r:      st.R,
+		bfs:    make([]*Filter, len(st.Filters)),
+	}
+	if g.h == nil {
+		for _, option := range withDefault(nil) {
+			option(&g.params)
+		}
+	}
+	g.e = st.E
+	g.p = st.P
+	g.maxBytes = uint(st.MaxBytes)
+	g.schedule = st.Schedule
+	g.maxFilters = int(st.MaxFilters)
+	g.growth = st.Growth
+
+	// Sub-filters added from now on use the encoded fill ratio.
+	g.opt = append(g.opt, WithFillRatio(g.p))
+
+	// Each sub-filter's capacity is read from its own encoding rather than
+	// recomputed from the growth factor, which no longer predicts it once a
+	// stage has been removed.
+	for i, data := range st.Filters {
+		bf := &Filter{params: g.params}
+		if err := bf.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		g.bfs[i] = bf
+	}
+
+	if st.Guard != nil {
+		g.guard = &Filter{}
+		sameHashing(&g.params)(&g.guard.params)
+		if err := g.guard.UnmarshalBinary(st.Guard); err != nil {
+			return err
+		}
+	}
+
+	*sbf = g
+	return nil
+}
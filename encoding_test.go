@@ -0,0 +1,590 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Filter
+	if err = got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Count() != bf.Count() || got.m != bf.m || got.k != bf.k || got.s != bf.s {
+		t.Fatal("expected decoded geometry to match the original")
+	}
+
+	for _, w := range web2[:10000] {
+		if !got.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present after decoding", w)
+		}
+	}
+
+	if err = got.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("expected an error decoding truncated data")
+	}
+}
+
+func TestRegisterGob(t *testing.T) {
+	t.Parallel()
+
+	RegisterGob()
+
+	bf := New(1000)
+	bf.Add([]byte("hello"))
+
+	type envelope struct {
+		Filter interface{}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(envelope{Filter: bf}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got envelope
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	f, ok := got.Filter.(*Filter)
+	if !ok {
+		t.Fatalf("expected *Filter, got %T", got.Filter)
+	}
+
+	if !f.Check([]byte("hello")) {
+		t.Error("expected decoded filter to contain the added item")
+	}
+}
+
+func TestNewFromBytes(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	data := bf.PartitionBytes()
+
+	got, err := NewFromBytes(10000, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range bf.b {
+		if !bf.b[i].Equal(got.b[i]) {
+			t.Fatalf("partition %d differs", i)
+		}
+	}
+
+	for _, w := range web2[:10000] {
+		if !got.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	if c := got.Count(); c < 9500 || c > 10500 {
+		t.Errorf("expected an estimated count near 10000, got %d", c)
+	}
+
+	// A misaligned buffer is copied rather than aliased.
+	misaligned := append(make([]byte, 1, len(data)+1), data...)[1:]
+	if got, err = NewFromBytes(10000, misaligned); err != nil || !got.Check([]byte(web2[0])) {
+		t.Fatalf("expected misaligned data to load, got %v", err)
+	}
+
+	if _, err = NewFromBytes(10000, data[:len(data)-8]); err == nil {
+		t.Error("expected an error for data of the wrong length")
+	}
+}
+
+func TestFromPartitions(t *testing.T) {
+	t.Parallel()
+
+	const k, s = 7, 1000
+
+	// Set the bits of one key by hand, in partitions as another tool would
+	// build them, the last one longer than s.
+	key := []byte("hello")
+	a, b := New(10).Digest(key)
+
+	parts := make([]*bitset.BitSet, k)
+	for i := range parts {
+		parts[i] = bitset.New(s)
+		if i == k-1 {
+			parts[i] = bitset.New(s + 100)
+			parts[i].Set(s + 50)
+		}
+		parts[i].Set((uint(a) + uint(b)*uint(i)) % s)
+	}
+
+	bf, err := FromPartitions(parts, k, s, 0.01, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bf.Check(key) {
+		t.Error("expected the key set by hand to be present")
+	}
+
+	if bf.Check([]byte("world")) {
+		t.Error("expected another key to be absent")
+	}
+
+	if bf.b[0] != parts[0] {
+		t.Error("expected a partition of s bits to be adopted")
+	}
+
+	if bf.b[k-1].Len() != s || bf.b[k-1].Count() != 1 {
+		t.Errorf("expected a longer partition to be truncated to %d bits", s)
+	}
+
+	if bf.Count() != 1 || bf.Capacity() == 0 {
+		t.Errorf("expected a count of 1 and a capacity, got %d and %d", bf.Count(), bf.Capacity())
+	}
+
+	short := append([]*bitset.BitSet{bitset.New(s - 1)}, parts[1:]...)
+	withNil := append([]*bitset.BitSet{nil}, parts[1:]...)
+	for _, tc := range []struct {
+		name string
+		b    []*bitset.BitSet
+		e    float64
+	}{
+		{"too few partitions", parts[1:], 0.01},
+		{"nil partition", withNil, 0.01},
+		{"short partition", short, 0.01},
+		{"error rate out of range", parts, 1},
+	} {
+		if _, err := FromPartitions(tc.b, k, s, tc.e, 0.5); err == nil {
+			t.Errorf("expected an error for %s", tc.name)
+		}
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("header")
+	if _, err := bf.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("footer")
+
+	header := make([]byte, 6)
+	buf.Read(header)
+
+	var got Filter
+	if _, err := got.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if rest := buf.String(); rest != "footer" {
+		t.Fatalf("expected the reader to be positioned at the footer, got %q", rest)
+	}
+
+	for _, w := range web2[:1000] {
+		if !got.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	t.Parallel()
+
+	var (
+		readers []io.Reader
+		union   = New(20000)
+	)
+
+	for i := 0; i < 4; i++ {
+		bf := New(20000)
+		for _, w := range web2[i*5000 : (i+1)*5000] {
+			bf.Add([]byte(w))
+		}
+
+		if err := union.Merge(bf); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := bf.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, &buf)
+	}
+
+	var out bytes.Buffer
+	if err := MergeStreams(&out, readers...); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Filter
+	if _, err := got.ReadFrom(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Count() != 20000 || union.Count() != 20000 {
+		t.Errorf("expected merged count 20000, got %d and %d", got.Count(), union.Count())
+	}
+
+	for i := range got.b {
+		if !got.b[i].Equal(union.b[i]) {
+			t.Fatalf("partition %d differs from the in-memory union", i)
+		}
+	}
+
+	for _, w := range web2[:20000] {
+		if !got.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	var a, b bytes.Buffer
+	New(1000).WriteTo(&a)
+	New(2000).WriteTo(&b)
+	if err := MergeStreams(&out, &a, &b); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+
+	if err := New(1000).Merge(New(2000)); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestDecodeSizeOverflow(t *testing.T) {
+	t.Parallel()
+
+	// With 2^31 partitions of 2^33 bytes, the size of the encoding wraps
+	// around to that of the header alone.
+	h := header{n: 1, m: 1 << 37, k: 1 << 31, s: 1 << 36, e: 0.01, p: 0.5}
+
+	// writeTo writes the current version; version 2 has no checksums.
+	var data bytes.Buffer
+	h.writeTo(&data)
+	data.Bytes()[0] = 2
+
+	var f Filter
+	if err := f.UnmarshalBinary(data.Bytes()); err != ErrInvalidEncoding {
+		t.Errorf("UnmarshalBinary: expected ErrInvalidEncoding, got %v", err)
+	}
+
+	var stream bytes.Buffer
+	binary.Write(&stream, binary.BigEndian, uint64(data.Len()))
+	stream.Write(data.Bytes())
+
+	if _, err := f.ReadFrom(bytes.NewReader(stream.Bytes())); err != ErrInvalidEncoding {
+		t.Errorf("ReadFrom: expected ErrInvalidEncoding, got %v", err)
+	}
+
+	if err := MergeStreams(io.Discard, bytes.NewReader(stream.Bytes())); err != ErrInvalidEncoding {
+		t.Errorf("MergeStreams: expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestMergeStreamsOversized(t *testing.T) {
+	t.Parallel()
+
+	// A header claiming far more bits than any filter may have must be
+	// rejected before anything is sized from it.
+	h := header{n: 1, m: 1000 << 37, k: 1000, s: 1 << 37, e: 0.01, p: 0.5}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, h.size())
+	h.writeTo(&buf)
+	buf.Write(make([]byte, 4096))
+
+	if err := MergeStreams(io.Discard, &buf); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+// golden is the binary encoding of a filter sized for 4 items holding
+// "alpha", "beta" and "gamma": the version byte, the big-endian header, then
+// the 10 partitions of 6 bits, each one little-endian word followed by its
+// little-endian CRC-32C.
+const golden = "03" +
+	"0000000000000004" + "000000000000003a" + "000000000000000a" + "0000000000000006" +
+	"0000000000000003" + "3f50624dd2f1a9fc" + "3fe0000000000000" +
+	"1500000000000000" + "74447f60" + "0600000000000000" + "a9ca4d3f" +
+	"1100000000000000" + "19c66241" + "3100000000000000" + "80a3624c" +
+	"1400000000000000" + "53394329" + "1900000000000000" + "c3c25903" +
+	"1500000000000000" + "74447f60" + "0600000000000000" + "a9ca4d3f" +
+	"1100000000000000" + "19c66241" + "3100000000000000" + "80a3624c"
+
+func TestBinaryGolden(t *testing.T) {
+	t.Parallel()
+
+	words := []string{"alpha", "beta", "gamma"}
+
+	bf := New(4)
+	for _, w := range words {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hex.EncodeToString(data); got != golden {
+		t.Fatalf("encoding differs from the golden bytes:\n got %s\nwant %s", got, golden)
+	}
+
+	v3, _ := hex.DecodeString(golden)
+
+	// Version 2 had no checksums, and version 1 encoded the partition words
+	// big-endian.
+	v2 := append([]byte{2}, v3[1:headerSize]...)
+	v1 := append([]byte{1}, v3[1:headerSize]...)
+	for o := headerSize; o < len(v3); o += 12 {
+		v2 = append(v2, v3[o:o+8]...)
+		v1 = append(v1, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(v1[len(v1)-8:], binary.LittleEndian.Uint64(v3[o:]))
+	}
+
+	for v, data := range [][]byte{v3, v2, v1} {
+		var g Filter
+		if err := g.UnmarshalBinary(data); err != nil {
+			t.Fatalf("version %d: %v", 3-v, err)
+		}
+
+		for _, w := range words {
+			if !g.Check([]byte(w)) {
+				t.Fatalf("version %d: expected %q to be present", 3-v, w)
+			}
+		}
+
+		for i := range bf.b {
+			if !g.b[i].Equal(bf.b[i]) {
+				t.Fatalf("version %d: partition %d differs", 3-v, i)
+			}
+		}
+	}
+}
+
+func TestLoadLenient(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt a byte of partition 2.
+	h, _ := readHeader(bytes.NewReader(data))
+	data[headerSize+2*h.partitionSize()+5] ^= 0xff
+
+	var strict Filter
+	var cpe *CorruptPartitionsError
+	if err := strict.UnmarshalBinary(data); !errors.As(err, &cpe) || len(cpe.Partitions) != 1 || cpe.Partitions[0] != 2 {
+		t.Fatalf("expected partition 2 to be reported corrupt, got %v", err)
+	}
+
+	if strict.b != nil {
+		t.Fatal("expected a strict load to leave the receiver unchanged")
+	}
+
+	var lenient Filter
+	if err := lenient.LoadLenient(data); !errors.As(err, &cpe) || len(cpe.Partitions) != 1 || cpe.Partitions[0] != 2 {
+		t.Fatalf("expected partition 2 to be reported corrupt, got %v", err)
+	}
+
+	for i := range bf.b {
+		if i == 2 {
+			if lenient.b[i].Any() {
+				t.Error("expected the corrupt partition to be zeroed")
+			}
+		} else if !lenient.b[i].Equal(bf.b[i]) {
+			t.Errorf("partition %d differs", i)
+		}
+	}
+
+	// Items test absent in the zeroed partition.
+	if lenient.Check([]byte(web2[0])) {
+		t.Error("expected a false negative with a zeroed partition")
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000, WithErrorRate(.01))
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(data)
+	h, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Header{
+		Version:   version,
+		N:         10000,
+		M:         uint64(bf.m),
+		K:         uint64(bf.k),
+		S:         uint64(bf.s),
+		Count:     5000,
+		ErrorRate: .01,
+		FillRatio: bf.p,
+	}
+	if h != want {
+		t.Fatalf("expected header %+v, got %+v", want, h)
+	}
+
+	if rest := uint64(r.Len()); rest != h.K*((h.S+63)/64*8+4) {
+		t.Errorf("expected the %d bytes of partition data to be left unread, got %d", h.K*((h.S+63)/64*8+4), rest)
+	}
+
+	if _, err := ReadHeader(bytes.NewReader(data[:10])); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding for a short header, got %v", err)
+	}
+}
+
+func TestScalableGob(t *testing.T) {
+	t.Parallel()
+
+	RegisterGob()
+
+	bf := NewScalable(1000, WithErrorRate(.01), WithFillRatio(.4))
+	for _, w := range web2[:3000] {
+		bf.Add([]byte(w))
+	}
+
+	if len(bf.bfs) < 3 {
+		t.Fatalf("expected at least 3 sub-filters, got %d", len(bf.bfs))
+	}
+
+	type envelope struct {
+		Filter interface{}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(envelope{Filter: bf}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got envelope
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	g, ok := got.Filter.(*ScalableFilter)
+	if !ok {
+		t.Fatalf("expected *ScalableFilter, got %T", got.Filter)
+	}
+
+	if g.Count() != bf.Count() || len(g.bfs) != len(bf.bfs) {
+		t.Fatalf("expected %d items in %d sub-filters, got %d in %d",
+			bf.Count(), len(bf.bfs), g.Count(), len(g.bfs))
+	}
+
+	for i, e := range bf.ErrorSchedule() {
+		if g.ErrorSchedule()[i] != e {
+			t.Errorf("sub-filter %d: expected error rate %g, got %g", i, e, g.ErrorSchedule()[i])
+		}
+	}
+
+	for _, w := range append(web2[:5000:5000], web2a[:5000]...) {
+		if g.Check([]byte(w)) != bf.Check([]byte(w)) {
+			t.Fatalf("membership of %q differs after decoding", w)
+		}
+	}
+
+	// The decoded filter keeps growing as the original does.
+	for _, w := range web2[3000:6000] {
+		bf.Add([]byte(w))
+		g.Add([]byte(w))
+	}
+
+	if len(g.bfs) != len(bf.bfs) {
+		t.Fatalf("expected %d sub-filters after growing, got %d", len(bf.bfs), len(g.bfs))
+	}
+
+	for i := range bf.bfs {
+		if g.bfs[i].k != bf.bfs[i].k || g.bfs[i].s != bf.bfs[i].s {
+			t.Fatalf("sub-filter %d differs in geometry after growing", i)
+		}
+	}
+}
+
+func TestScalableGobInvalidSchedule(t *testing.T) {
+	t.Parallel()
+
+	data, err := NewScalable(1000, WithErrorSchedule([]float64{.01, .001})).GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []float64{0, 1, math.NaN()} {
+		var st scalableState
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		st.Schedule[1] = e
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+			t.Fatal(err)
+		}
+
+		var g ScalableFilter
+		if err := g.GobDecode(buf.Bytes()); err != ErrInvalidEncoding {
+			t.Errorf("schedule rate %g: expected ErrInvalidEncoding, got %v", e, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// FixedHasher returns a hash whose successive Sums are the supplied values,
+// in order and regardless of what was written, cycling back to the first
+// value once all have been returned.  Each value v yields the index inputs
+// a = uint32(v) and b = uint32(v >> 32) (see AddPrehashed).
+//
+// FixedHasher is intended for tests only, to control exactly which bits an
+// item maps to.  Reset does not rewind the sequence.
+func FixedHasher(values ...uint64) hash.Hash {
+	if len(values) == 0 {
+		values = []uint64{0}
+	}
+	return &fixedHash{values: values}
+}
+
+type fixedHash struct {
+	values []uint64
+	next   int
+}
+
+func (h *fixedHash) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (h *fixedHash) Sum(b []byte) []byte {
+	v := h.values[h.next]
+	h.next = (h.next + 1) % len(h.values)
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func (h *fixedHash) Reset() {}
+
+func (h *fixedHash) Size() int {
+	return 8
+}
+
+func (h *fixedHash) BlockSize() int {
+	return 1
+}
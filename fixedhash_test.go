@@ -0,0 +1,51 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestFixedHasherCollision(t *testing.T) {
+	t.Parallel()
+
+	const a, b = 3, 5
+
+	bf := New(1000, WithHash(FixedHasher(b<<32|a)))
+	bf.Add([]byte("foo"))
+
+	for i, p := range bf.b {
+		if want := (a + b*uint(i)) % bf.s; p.Count() != 1 || !p.Test(want) {
+			t.Fatalf("expected partition %d to have only bit %d set", i, want)
+		}
+	}
+
+	if !bf.Check([]byte("bar")) {
+		t.Error("expected an item with the same hash to collide")
+	}
+}
+
+func TestFixedHasherSequence(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000, WithHash(FixedHasher(1, 2)))
+	bf.Add([]byte("foo")) // 1
+
+	if bf.Check([]byte("foo")) { // 2
+		t.Error("expected the second value not to collide with the first")
+	}
+
+	if !bf.Check([]byte("bar")) { // 1
+		t.Error("expected the sequence to cycle back to the first value")
+	}
+}
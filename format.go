@@ -0,0 +1,178 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+// Formats chosen by MarshalOptimal.  The format is the first byte of the
+// encoding.
+const (
+	// FormatDense is the MarshalBinary encoding.
+	FormatDense byte = iota
+
+	// FormatSparse lists the positions of the bits set in each partition,
+	// and suits filters with a low fill ratio.  It is only used for filters
+	// of at most maxSparseBits bits.
+	FormatSparse
+
+	// FormatGzip is the gzip-compressed MarshalBinary encoding.
+	FormatGzip
+)
+
+// maxSparseBits is the largest number of bits, 16 MiB worth, of a filter in
+// FormatSparse.  The encoding of an empty filter is a few bytes whatever its
+// size, so that without a cap well below DefaultMaxBits a tiny input could
+// make UnmarshalOptimal allocate gigabytes.  Larger sparse filters are left
+// to FormatGzip, whose decoder reads no more than the data provides.
+const maxSparseBits = 1 << 27
+
+// MarshalOptimal encodes the filter in whichever of FormatDense, FormatSparse
+// and FormatGzip is smallest for its current fill, and returns the encoding
+// along with the chosen format.  The encoding is decoded by UnmarshalOptimal.
+func (f *Filter) MarshalOptimal() ([]byte, byte, error) {
+	dense, err := f.MarshalBinary()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	best := append([]byte{FormatDense}, dense...)
+
+	for _, enc := range []func([]byte) ([]byte, error){
+		func([]byte) ([]byte, error) {
+			if uint64(f.k)*uint64(f.s) > maxSparseBits {
+				return nil, nil
+			}
+			return f.marshalSparse(), nil
+		},
+		func(dense []byte) ([]byte, error) { return marshalGzip(FormatGzip, dense) },
+	} {
+		data, err := enc(dense)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if data != nil && len(data) < len(best) {
+			best = data
+		}
+	}
+
+	return best, best[0], nil
+}
+
+// UnmarshalOptimal decodes an encoding produced by MarshalOptimal.  Like
+// UnmarshalBinary, it keeps the receiver's hasher and options.
+func (f *Filter) UnmarshalOptimal(data []byte) error {
+	if len(data) == 0 {
+		return ErrInvalidEncoding
+	}
+
+	switch data[0] {
+	case FormatDense:
+		return f.UnmarshalBinary(data[1:])
+	case FormatSparse:
+		return f.unmarshalSparse(data[1:])
+	case FormatGzip:
+		dense, err := unmarshalGzip(data[1:], f.maxBits)
+		if err != nil {
+			return err
+		}
+
+		return f.UnmarshalBinary(dense)
+	default:
+		return fmt.Errorf("bloom: unknown format %d", data[0])
+	}
+}
+
+// marshalSparse returns the FormatSparse encoding: the format byte, the
+// binary encoding's version byte and header, then for each partition the
+// number of bits set followed by the delta from each set bit's position to
+// the previous one, all as uvarints.
+func (f *Filter) marshalSparse() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(FormatSparse)
+	f.header().writeTo(&buf)
+
+	var v [binary.MaxVarintLen64]byte
+	for _, b := range f.partitions() {
+		buf.Write(v[:binary.PutUvarint(v[:], uint64(b.Count()))])
+
+		prev := uint(0)
+		for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+			buf.Write(v[:binary.PutUvarint(v[:], uint64(i-prev))])
+			prev = i
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func (f *Filter) unmarshalSparse(data []byte) error {
+	r := bytes.NewReader(data)
+
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	g := f.decoded(h)
+
+	// Unlike the dense encoding, the size of the partitions is not bounded
+	// by the size of the data, so bound it by maxSparseBits, or the maximum
+	// size of a filter if smaller.
+	limit := uint64(maxSparseBits)
+	if f.maxBits != 0 && f.maxBits < limit {
+		limit = f.maxBits
+	}
+	if h.k > uint64(r.Len()) || h.s > limit/h.k {
+		return ErrInvalidEncoding
+	}
+
+	g.b = make([]*bitset.BitSet, g.k)
+	for i := range g.b {
+		g.b[i] = bitset.New(g.s)
+
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > h.s {
+			return ErrInvalidEncoding
+		}
+
+		pos := uint64(0)
+		for j := uint64(0); j < n; j++ {
+			d, err := binary.ReadUvarint(r)
+			if err != nil || pos+d >= h.s {
+				return ErrInvalidEncoding
+			}
+
+			pos += d
+			g.b[i].Set(uint(pos))
+		}
+	}
+
+	if r.Len() != 0 {
+		return ErrInvalidEncoding
+	}
+
+	g.finishDecode()
+
+	*f = g
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestMarshalOptimal(t *testing.T) {
+	t.Parallel()
+
+	formats := make(map[byte]bool)
+
+	for _, count := range []int{0, 10, 100, 1000, 10000, 50000} {
+		bf := New(10000)
+		for _, w := range web2[:count] {
+			bf.Add([]byte(w))
+		}
+
+		data, format, err := bf.MarshalOptimal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data[0] != format {
+			t.Fatalf("expected format %d to lead the encoding", format)
+		}
+		formats[format] = true
+
+		dense, _ := bf.MarshalBinary()
+		gz, _ := marshalGzip(FormatGzip, dense)
+		for _, n := range []int{len(dense) + 1, len(bf.marshalSparse()), len(gz)} {
+			if n < len(data) {
+				t.Errorf("%d items: format %d is %d bytes, but another format is %d", count, format, len(data), n)
+			}
+		}
+
+		var got Filter
+		if err = got.UnmarshalOptimal(data); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range bf.b {
+			if !bf.b[i].Equal(got.b[i]) {
+				t.Fatalf("%d items: partition %d differs after decoding", count, i)
+			}
+		}
+
+		if got.Count() != bf.Count() {
+			t.Errorf("%d items: expected count %d, got %d", count, bf.Count(), got.Count())
+		}
+	}
+
+	if !formats[FormatSparse] || !formats[FormatGzip] && !formats[FormatDense] {
+		t.Errorf("expected sparse and dense or gzip formats to be chosen, got %v", formats)
+	}
+}
+
+func TestUnmarshalOptimalGzipBounded(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	dense, _ := bf.MarshalBinary()
+
+	// A stream decompressing to far more than its header implies, another
+	// with data following the compressed stream, and one whose header claims
+	// more bits than a filter may have.
+	var bomb bytes.Buffer
+	bomb.WriteByte(FormatGzip)
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(dense)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+
+	gz, _ := marshalGzip(FormatGzip, dense)
+	trailing := append(gz[:len(gz):len(gz)], 0)
+
+	h := header{n: 1, m: 1000 << 37, k: 1000, s: 1 << 37, e: 0.01, p: 0.5}
+	var huge bytes.Buffer
+	huge.WriteByte(FormatGzip)
+	zw = gzip.NewWriter(&huge)
+	h.writeTo(zw)
+	zw.Close()
+
+	var got Filter
+	for _, b := range [][]byte{bomb.Bytes(), trailing, huge.Bytes()} {
+		if err := got.UnmarshalOptimal(b); err != ErrInvalidEncoding {
+			t.Errorf("expected ErrInvalidEncoding, got %v", err)
+		}
+	}
+
+	if err := got.UnmarshalOptimal(gz); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnmarshalOptimalSparseBounded(t *testing.T) {
+	t.Parallel()
+
+	// A header with a single huge empty partition is a few dozen bytes.
+	h := header{n: 1, m: 1 << 36, k: 1, s: 1 << 36, e: 0.01, p: 0.5}
+
+	var data bytes.Buffer
+	data.WriteByte(FormatSparse)
+	h.writeTo(&data)
+	data.WriteByte(0)
+
+	var got Filter
+	if err := got.UnmarshalOptimal(data.Bytes()); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+
+	// An empty filter too large for FormatSparse is encoded otherwise, and
+	// still round-trips.
+	bf := New(20000000)
+	enc, format, err := bf.MarshalOptimal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format == FormatSparse {
+		t.Errorf("expected a filter of %d bits not to use FormatSparse", bf.k*bf.s)
+	}
+	if err := got.UnmarshalOptimal(enc); err != nil {
+		t.Fatal(err)
+	}
+}
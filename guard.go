@@ -0,0 +1,98 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+// LookupGuard puts a bloom filter in front of an expensive backing store, so
+// that lookups of keys known to be absent never reach the store.  It adapts
+// to the false positives it observes: a key the filter reports present but
+// the store does not hold is remembered, so that looking it up again does not
+// reach the store either, until the key is added.
+type LookupGuard struct {
+	c      Checker
+	a      Adder
+	lookup func(key []byte) (bool, error)
+
+	// absent holds up to maxKnownAbsent keys found to be false positives.
+	absent map[string]struct{}
+
+	// lookups is the number of calls made to lookup.
+	lookups uint
+
+	// falsePositives is the number of lookups that found the key absent
+	// from the backing store although the filter reported it present.
+	falsePositives uint
+}
+
+// maxKnownAbsent is the number of false positives a LookupGuard remembers.
+// Once it is reached, an arbitrary one is forgotten for each new one.
+const maxKnownAbsent = 4096
+
+// NewLookupGuard returns a guard that consults c before calling lookup, and
+// records keys stored in the backing store through a.  c and a are usually the
+// same filter.
+func NewLookupGuard(c Checker, a Adder, lookup func(key []byte) (bool, error)) *LookupGuard {
+	return &LookupGuard{c: c, a: a, lookup: lookup, absent: make(map[string]struct{})}
+}
+
+// Contains reports whether key is in the backing store.  It returns false
+// without consulting the store if the filter reports the key absent, or if
+// the store was found not to hold it since it was last added.
+func (g *LookupGuard) Contains(key []byte) (bool, error) {
+	if !g.c.Check(key) {
+		return false, nil
+	}
+
+	if _, ok := g.absent[string(key)]; ok {
+		return false, nil
+	}
+
+	g.lookups++
+
+	ok, err := g.lookup(key)
+	if err == nil && !ok {
+		g.falsePositives++
+		g.remember(key)
+	}
+
+	return ok, err
+}
+
+// remember records key as a false positive, forgetting another one if
+// maxKnownAbsent are already recorded.
+func (g *LookupGuard) remember(key []byte) {
+	if len(g.absent) >= maxKnownAbsent {
+		for k := range g.absent {
+			delete(g.absent, k)
+			break
+		}
+	}
+	g.absent[string(key)] = struct{}{}
+}
+
+// Add records that key was stored in the backing store.
+func (g *LookupGuard) Add(key []byte) {
+	delete(g.absent, string(key))
+	g.a.Add(key)
+}
+
+// FalsePositiveRate returns the fraction of backing store lookups that found
+// the key absent.  A rate well above the filter's target error rate signals
+// that the filter is overloaded and should be rebuilt.
+func (g *LookupGuard) FalsePositiveRate() float64 {
+	if g.lookups == 0 {
+		return 0
+	}
+	return float64(g.falsePositives) / float64(g.lookups)
+}
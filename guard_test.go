@@ -0,0 +1,83 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestLookupGuard(t *testing.T) {
+	t.Parallel()
+
+	store := make(map[string]bool)
+	calls := 0
+
+	bf := New(10000)
+	g := NewLookupGuard(bf, bf, func(key []byte) (bool, error) {
+		calls++
+		return store[string(key)], nil
+	})
+
+	for _, w := range web2[:10000] {
+		store[w] = true
+		g.Add([]byte(w))
+	}
+
+	for _, w := range web2[:10000] {
+		if ok, err := g.Contains([]byte(w)); !ok || err != nil {
+			t.Fatalf("expected %q to be found, got %v, %v", w, ok, err)
+		}
+	}
+
+	calls = 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			continue
+		}
+
+		before := calls
+		if ok, _ := g.Contains([]byte(w)); ok {
+			t.Fatalf("expected %q to be absent", w)
+		}
+
+		if calls != before {
+			t.Fatalf("backing store consulted on a bloom miss for %q", w)
+		}
+	}
+
+	if r := g.FalsePositiveRate(); r > 0.01 {
+		t.Errorf("unexpected false-positive rate %.4f", r)
+	}
+
+	// A false positive reaches the store once, until the key is added.
+	for _, w := range web2a {
+		if _, ok := store[w]; ok || !bf.Check([]byte(w)) {
+			continue
+		}
+
+		calls = 0
+		g.Contains([]byte(w))
+		g.Contains([]byte(w))
+		if calls != 1 {
+			t.Fatalf("expected the false positive %q to reach the store once, got %d", w, calls)
+		}
+
+		store[w] = true
+		g.Add([]byte(w))
+		if ok, _ := g.Contains([]byte(w)); !ok || calls != 2 {
+			t.Fatalf("expected %q to be found in the store once added", w)
+		}
+		return
+	}
+	t.Fatal("expected a false positive among web2a")
+}
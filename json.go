@@ -0,0 +1,86 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// jsonFilter is the JSON encoding of a Filter.
+type jsonFilter struct {
+	M         uint    `json:"m"`
+	K         uint    `json:"k"`
+	S         uint    `json:"s"`
+	N         uint    `json:"n"`
+	C         uint    `json:"c"`
+	E         float64 `json:"e"`
+	P         float64 `json:"p"`
+	FillRatio float64 `json:"fill_ratio"`
+
+	// Data is the MarshalBinary encoding of the filter, which encoding/json
+	// encodes in base64.
+	Data []byte `json:"data,omitempty"`
+}
+
+func (f *Filter) jsonFilter() jsonFilter {
+	return jsonFilter{
+		M:         f.m,
+		K:         f.k,
+		S:         f.s,
+		N:         f.n,
+		C:         f.c,
+		E:         f.e,
+		P:         f.p,
+		FillRatio: f.FillRatio(),
+	}
+}
+
+// MarshalJSON implements json.Marshaler.  It encodes the filter's geometry,
+// count, error and fill ratios, and current fill ratio, but not its bits, so
+// that the filter can be logged compactly.  See MarshalJSONFull.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.jsonFilter())
+}
+
+// MarshalJSONFull is like MarshalJSON, but also encodes the filter's bits,
+// as the base64 of MarshalBinary in the field "data", so that UnmarshalJSON
+// can restore the filter.
+func (f *Filter) MarshalJSONFull() ([]byte, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	j := f.jsonFilter()
+	j.Data = data
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  It decodes the encoding produced
+// by MarshalJSONFull; that of MarshalJSON, which holds no bits, cannot be
+// decoded.  As with UnmarshalBinary, the hasher is not encoded.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	var j jsonFilter
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	if j.Data == nil {
+		return errors.New("bloom: JSON holds no filter data")
+	}
+
+	return f.UnmarshalBinary(j.Data)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000, WithErrorRate(0.01))
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := json.Marshal(bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := fields["data"]; ok {
+		t.Error("expected no bits in the metadata encoding")
+	}
+
+	for _, name := range []string{"m", "k", "s", "n", "c"} {
+		if strings.ContainsAny(string(fields[name]), ".eE") {
+			t.Errorf("expected %s to be a plain integer, got %s", name, fields[name])
+		}
+	}
+
+	var j jsonFilter
+	if err := json.Unmarshal(data, &j); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := bf.jsonFilter(); !reflect.DeepEqual(j, want) {
+		t.Errorf("expected metadata %+v, got %+v", want, j)
+	}
+
+	if err := new(Filter).UnmarshalJSON(data); err == nil {
+		t.Error("expected an error decoding metadata without bits")
+	}
+}
+
+func TestMarshalJSONFull(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+	}
+
+	data, err := bf.MarshalJSONFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g Filter
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatal(err)
+	}
+
+	if !g.Equal(bf) || g.Count() != bf.Count() {
+		t.Error("expected the decoded filter to equal the original")
+	}
+
+	for _, w := range web2[:5000] {
+		if !g.Check([]byte(w)) {
+			t.Fatalf("expected %q to be found", w)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "encoding/hex"
+
+// SyntheticKeys returns count pseudo-random keys derived from seed, as 16
+// hexadecimal digits each, for reproducible benchmarks and false-positive
+// measurements that do not depend on a dictionary.  The same seed always
+// yields the same keys.  As long as count and seed are below 2^32, the keys
+// are distinct, and distinct from those of any other seed, so that keys of one
+// seed can serve as members and those of another as non-members.
+func SyntheticKeys(count int, seed uint64) [][]byte {
+	keys := make([][]byte, count)
+	for i := range keys {
+		var b [8]byte
+		x := splitmix64(seed<<32 | uint64(i))
+		for j := range b {
+			b[j] = byte(x >> (56 - 8*j))
+		}
+
+		keys[i] = make([]byte, 16)
+		hex.Encode(keys[i], b[:])
+	}
+	return keys
+}
+
+// splitmix64 is the SplitMix64 finalizer, a bijection on uint64, so that
+// distinct inputs give distinct outputs.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSyntheticKeys(t *testing.T) {
+	t.Parallel()
+
+	a, b := SyntheticKeys(10000, 1), SyntheticKeys(10000, 1)
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			t.Fatalf("key %d differs for the same seed: %q and %q", i, a[i], b[i])
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, keys := range [][][]byte{a, SyntheticKeys(10000, 2)} {
+		for _, key := range keys {
+			if seen[string(key)] {
+				t.Fatalf("key %q repeated", key)
+			}
+			seen[string(key)] = true
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// CheckLines reads newline-delimited queries from r, and writes each line,
+// newline-terminated, to found if it is in the filter and to missing
+// otherwise, so that the filter can partition a stream of queries in a
+// pipeline without holding them in memory.  A trailing "\r" is stripped from
+// each line, lines may be of any length, and a nil writer discards its lines.
+func (f *Filter) CheckLines(r io.Reader, found, missing io.Writer) error {
+	if found == nil {
+		found = io.Discard
+	}
+	if missing == nil {
+		missing = io.Discard
+	}
+
+	br := bufio.NewReader(r)
+	fw, mw := bufio.NewWriter(found), bufio.NewWriter(missing)
+
+	for {
+		// ReadBytes, unlike a bufio.Scanner, is not limited to lines that
+		// fit its buffer.
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+			w := mw
+			if f.Check(line) {
+				w = fw
+			}
+
+			w.Write(line)
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fw.Flush(); err != nil {
+		return err
+	}
+	return mw.Flush()
+}
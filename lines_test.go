@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckLines(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	for _, w := range web2[:1000] {
+		bf.Add([]byte(w))
+	}
+
+	long := strings.Repeat("x", 1<<17)
+
+	var queries []string
+	for i := 0; i < 100; i++ {
+		queries = append(queries, web2[i], web2[1000+i])
+	}
+	queries = append(queries, long)
+
+	var found, missing bytes.Buffer
+	in := strings.Join(queries, "\r\n")
+	if err := bf.CheckLines(strings.NewReader(in), &found, &missing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Non-members may be false positives, but are rare.
+	lines := strings.Split(strings.TrimSuffix(found.String(), "\n"), "\n")
+	if len(lines) < 100 || len(lines) > 105 {
+		t.Fatalf("expected about 100 lines in found, got %d", len(lines))
+	}
+
+	members := make(map[string]bool)
+	for _, l := range lines {
+		members[l] = true
+	}
+	for _, w := range web2[:100] {
+		if !members[w] {
+			t.Errorf("expected %q in found", w)
+		}
+		if strings.Contains(missing.String(), w+"\n") {
+			t.Errorf("expected %q not to be in missing", w)
+		}
+	}
+
+	if !strings.HasSuffix(missing.String(), long+"\n") {
+		t.Error("expected the long line in missing")
+	}
+
+	if n := strings.Count(missing.String(), "\n"); n+len(lines) != len(queries) {
+		t.Errorf("expected %d lines in missing, got %d", len(queries)-len(lines), n)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "encoding/binary"
+
+// AddNamespaced adds item within the namespace ns, so that the same item added
+// in different namespaces maps to different bits.  The filter then holds the
+// key formed by ns prefixed with its length as a uvarint, followed by item.
+// The length prefix acts as the separator: unlike a separator byte, it
+// cannot occur in ns, so no two distinct (ns, item) pairs form the same key.
+func (f *Filter) AddNamespaced(ns, item []byte) {
+	f.Add(namespaced(ns, item))
+}
+
+// CheckNamespaced reports whether item may have been added within the
+// namespace ns with AddNamespaced.
+func (f *Filter) CheckNamespaced(ns, item []byte) bool {
+	return f.Check(namespaced(ns, item))
+}
+
+// namespaced returns the key of item within the namespace ns.
+func namespaced(ns, item []byte) []byte {
+	key := make([]byte, binary.MaxVarintLen64+len(ns)+len(item))
+	n := binary.PutUvarint(key, uint64(len(ns)))
+	n += copy(key[n:], ns)
+	n += copy(key[n:], item)
+	return key[:n]
+}
+
+// AddFields adds the composite key made of fields, so that keys split into
+// fields differently, such as ("ab", "c") and ("a", "bc"), map to different
+// bits.  The filter holds the canonical encoding of the key: each field
+// prefixed with its length as a uvarint, in order.
+func (f *Filter) AddFields(fields ...[]byte) {
+	f.Add(fieldsKey(fields))
+}
+
+// CheckFields reports whether the composite key made of fields may have been
+// added with AddFields.
+func (f *Filter) CheckFields(fields ...[]byte) bool {
+	return f.Check(fieldsKey(fields))
+}
+
+// fieldsKey returns the canonical encoding of the composite key made of
+// fields.
+func fieldsKey(fields [][]byte) []byte {
+	size := 0
+	for _, field := range fields {
+		size += binary.MaxVarintLen64 + len(field)
+	}
+
+	key := make([]byte, size)
+	n := 0
+	for _, field := range fields {
+		n += binary.PutUvarint(key[n:], uint64(len(field)))
+		n += copy(key[n:], field)
+	}
+	return key[:n]
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestNamespaced(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	for _, w := range web2 {
+		bf.AddNamespaced([]byte("users"), []byte(w))
+	}
+
+	fp := 0
+	for _, w := range web2 {
+		if !bf.CheckNamespaced([]byte("users"), []byte(w)) {
+			t.Fatalf("expected %q to be present in its namespace", w)
+		}
+
+		if bf.CheckNamespaced([]byte("orders"), []byte(w)) {
+			fp++
+		}
+	}
+
+	if rate := float64(fp) / float64(len(web2)); rate > 2*bf.e {
+		t.Errorf("items found in another namespace at rate %g, beyond the error rate %g", rate, bf.e)
+	}
+
+	// The length prefix keeps the boundary between namespace and item.
+	if k1, k2 := namespaced([]byte("ab"), []byte("c")), namespaced([]byte("a"), []byte("bc")); string(k1) == string(k2) {
+		t.Error("expected distinct namespace and item splits to form distinct keys")
+	}
+}
+
+func TestFields(t *testing.T) {
+	t.Parallel()
+
+	bf := New(1000)
+	bf.AddFields([]byte("ab"), []byte("c"))
+
+	if !bf.CheckFields([]byte("ab"), []byte("c")) {
+		t.Fatal("expected the key to be present")
+	}
+
+	if bf.CheckFields([]byte("a"), []byte("bc")) || bf.CheckFields([]byte("abc")) || bf.Check([]byte("abc")) {
+		t.Error("expected keys split differently not to collide")
+	}
+
+	if string(fieldsKey([][]byte{[]byte("ab"), []byte("c")})) == string(fieldsKey([][]byte{[]byte("a"), []byte("bc")})) {
+		t.Error("expected distinct field boundaries to form distinct keys")
+	}
+}
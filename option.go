@@ -16,7 +16,11 @@
 package bloom
 
 import (
+	"encoding/binary"
+	"fmt"
 	"hash"
+	"io"
+	"sync"
 
 	"github.com/zentures/cityhash"
 )
@@ -24,6 +28,10 @@ import (
 type params struct {
 	h hash.Hash
 
+	// hashers, if set, holds hashers of the same kind as h for Check to
+	// borrow, so that concurrent checks need not share h.
+	hashers *sync.Pool
+
 	// e specifies the desired error rate for the filter.
 	// Smaller values of e imply a larger number of hash values used
 	// to set and test bits (the K parameter).
@@ -39,19 +47,134 @@ type params struct {
 	//
 	// If p <= 0, defaults to 0.5
 	p float64
+
+	// transform, if set, is applied to every item before hashing.
+	transform func([]byte) []byte
+
+	// onFPR, if set, is called once the estimated false-positive rate
+	// exceeds fprAlarmFactor times e.
+	onFPR func(observed, target float64)
+
+	// maxBits is the maximum number of bits a Filter may be sized to.
+	maxBits uint64
+
+	// maxBytes, if non-zero, bounds the memory a ScalableFilter may grow to.
+	maxBytes uint
+
+	// fillCounter maintains an atomic count of the bits set.
+	fillCounter bool
+
+	// schedule, if set, lists the error rates of a ScalableFilter's
+	// sub-filters.
+	schedule []float64
+
+	// counterBits is the width of the counters of a CountingFilter.
+	counterBits int
+
+	// wal, if set, receives a record of each item added.
+	wal io.Writer
+
+	// stages is the number of sub-filters a ScalableFilter preallocates
+	// room for.
+	stages int
+
+	// reducer, if set, maps a combined hash into a partition.
+	reducer func(hash uint64, bound uint) uint
+
+	// noReset skips resetting h before hashing each item.
+	noReset bool
+
+	// totalBits, if non-zero, is the total number of bits of a Filter,
+	// overriding the size computed from n, p and e.
+	totalBits uint
+
+	// hashCount, if non-zero, is the number of partitions of a Filter,
+	// overriding the number computed from e.
+	hashCount uint
+
+	// triple derives a third word from hashers with a 16-byte Sum.
+	triple bool
+
+	// maxFilters, if positive, bounds the number of sub-filters of a
+	// ScalableFilter.
+	maxFilters int
+
+	// growth, if non-zero, is the factor by which the capacity of each
+	// sub-filter of a ScalableFilter exceeds that of the previous one.
+	growth float64
+
+	// guard, if non-zero, is the capacity of a ScalableFilter's guard
+	// filter.
+	guard uint
+
+	// seed, if seeded is set, is written to the hasher before each item.
+	seed   [8]byte
+	seeded bool
 }
 
 type Option func(*params)
 
+// DefaultMaxBits is the default maximum number of bits of a filter, 8 GiB.
+const DefaultMaxBits = 1 << 36
+
+const (
+	// fprSampleInterval is the number of adds between checks of the
+	// estimated false-positive rate.
+	fprSampleInterval = 1024
+
+	// fprAlarmFactor is the multiple of the target error rate at which the
+	// FPR callback fires.
+	fprAlarmFactor = 2
+)
+
+// RecommendHasher returns a new instance of the hash used by default, 64-bit
+// CityHash.
+//
+// BenchmarkHashers finds no candidate that would justify changing it.  Over
+// web2/web2a at e = 0.001, every candidate (FNV-1, FNV-1a, CRC-64, Murmur3
+// and CityHash) holds the false-positive rate between 0.00098 and 0.0012,
+// and the cost of an Add, between about 130 and 280 ns, is dominated by
+// cache misses on the partitions rather than by hashing: the FNV and CRC
+// variants measure about 150 ns, CityHash 250 ns and Murmur3 270 ns on
+// short keys.  FNV's cost grows linearly with key length, where CityHash's
+// grows slowly, and changing the default would move every bit, so that
+// filters encoded by earlier releases would no longer find their items when
+// decoded with the default hasher.  CityHash is therefore kept.
+func RecommendHasher() hash.Hash {
+	return cityhash.New64()
+}
+
+// HashCloner is implemented by hashers that can copy themselves, including
+// any state, so that Clone can give each copy of a filter its own hasher.
+type HashCloner interface {
+	Clone() hash.Hash
+}
+
 // WithHash specifies the hash to use with the bloom filter.
-// If h == nil, defaults to CityHash.
+// If h == nil, defaults to RecommendHasher.
+//
+// Check hashes with h unless the filter can make more hashers of its kind,
+// which is the case for the default and with WithHashFunc.  A filter given h
+// therefore must not be checked concurrently.
 func WithHash(h hash.Hash) Option {
 	if h == nil {
-		h = cityhash.New64()
+		return WithHashFunc(RecommendHasher)
 	}
 
 	return func(ps *params) {
 		ps.h = h
+		ps.hashers = nil
+	}
+}
+
+// WithHashFunc specifies a constructor for the hash to use with the bloom
+// filter.  Besides the hasher used to add items, each Check borrows its own
+// from a pool filled by fn, so that any number of goroutines may call Check
+// concurrently, as long as none adds to the filter.
+func WithHashFunc(fn func() hash.Hash) Option {
+	return func(ps *params) {
+		ps.h = fn()
+		ps.hashers = &sync.Pool{New: func() interface{} { return fn() }}
 	}
 }
 
@@ -59,7 +182,8 @@ func WithHash(h hash.Hash) Option {
 // Smaller values of e imply a larger number of hash values used
 // to set and test bits (the K parameter).
 //
-// If e <= 0, defaults to .001.
+// If e <= 0, defaults to .001.  If e >= 1, New and NewScalable panic, and
+// NewChecked returns an error.
 func WithErrorRate(e float64) Option {
 	if e <= 0 {
 		e = .001
@@ -76,7 +200,8 @@ func WithErrorRate(e float64) Option {
 // fill ratio is not strictly enforced.  Overloading a filter happens
 // silently, causing the error rate (false positives) to increase.
 //
-// If p <= 0, defaults to 0.5
+// If p <= 0, defaults to 0.5.  If p >= 1, New and NewScalable panic, and
+// NewChecked returns an error.
 func WithFillRatio(p float64) Option {
 	if p <= 0 {
 		p = .5
@@ -87,10 +212,297 @@ func WithFillRatio(p float64) Option {
 	}
 }
 
+// WithNoHashReset stops the filter from calling Reset on its hasher before
+// hashing each item, saving the cost of the call.
+//
+// WARNING: this is only correct for hashers documented to return a Sum that
+// depends solely on the most recent Write, i.e. that are safe to reuse
+// without Reset between independent Sums.  Standard library hashers such as
+// fnv, crc64, md5 and sha1 accumulate state across Writes; using them with
+// this option makes every item's bits depend on all previous items, which
+// silently produces false negatives.  When in doubt, do not use it.
+func WithNoHashReset() Option {
+	return func(ps *params) {
+		ps.noReset = true
+	}
+}
+
+// WithKeyTransform specifies a function applied to every item before it is
+// hashed, for example to normalize case or trim whitespace.  Since the same
+// transform is applied by Add and Check, lookups are always consistent with
+// insertions.  The transform must be deterministic, and must not modify its
+// argument in place.
+func WithKeyTransform(fn func([]byte) []byte) Option {
+	return func(ps *params) {
+		ps.transform = fn
+	}
+}
+
+// WithFPRCallback specifies a function called when the filter's estimated
+// false-positive rate exceeds twice the target error rate, giving operators a
+// chance to act before accuracy collapses.  The estimate is sampled every 1024
+// adds, and fn is called at most once until the filter is Reset.  fn is
+// called synchronously from Add.
+func WithFPRCallback(fn func(observed, target float64)) Option {
+	return func(ps *params) {
+		ps.onFPR = fn
+	}
+}
+
+// WithMaxBytes bounds the total size in bytes of a ScalableFilter, as reported
+// by its SizeInBytes.  Once adding a sub-filter would exceed the limit, the
+// filter stops growing and further items are added to the newest sub-filter,
+// whose error rate then degrades beyond the target.  The first sub-filter is
+// always created.  It has no effect on a Filter.
+func WithMaxBytes(limit uint) Option {
+	return func(ps *params) {
+		ps.maxBytes = limit
+	}
+}
+
+// WithMaxFilters bounds the number of sub-filters of a ScalableFilter, and so
+// its memory, e.g. against adversarial input.  Once it has max sub-filters,
+// the filter stops growing as with WithMaxBytes, and reports through
+// Overloaded when items are added past the capacity of the newest one.  If
+// max <= 0, the number is unbounded, the default.  It has no effect on a
+// Filter.
+func WithMaxFilters(max int) Option {
+	return func(ps *params) {
+		ps.maxFilters = max
+	}
+}
+
+// WithGrowthFactor sizes sub-filter i of a ScalableFilter for n * g^i items
+// rather than n, so that a filter holding many items needs fewer sub-filters,
+// each of which Check scans.  The Scalable Bloom Filters paper suggests 2, or
+// 4 for fast growth.  g must be at least 1, otherwise NewScalable panics; if
+// g == 0, it defaults to 1, sizing every sub-filter for n items.  It has no
+// effect on a Filter.
+func WithGrowthFactor(g float64) Option {
+	return func(ps *params) {
+		ps.growth = g
+	}
+}
+
+// WithGuard gives a ScalableFilter a guard: a single filter sized for n items
+// that receives every item added, so that Check can report most absent items
+// after testing it alone rather than every sub-filter.  n should be the
+// number of items the filter is expected to grow to; a guard overloaded
+// beyond it still never causes false negatives, but lets more absent items
+// through to the sub-filters.  MergeIntoCurrent fails on a filter with a
+// guard, which would no longer cover every item.  It has no effect on a
+// Filter.
+func WithGuard(n uint) Option {
+	return func(ps *params) {
+		ps.guard = n
+	}
+}
+
+// WithHashSeed seeds the filter's hashing: every item is hashed as if
+// prefixed with the 8 big-endian bytes of seed, so that filters built with
+// the same hasher and seed agree on every item's bits, while those with
+// different seeds do not.  It works with any hasher; for one with a native
+// seed, such as murmur3.New64WithSeed, pass a seeded constructor to
+// WithHashFunc instead.  The seed is not encoded, so a decoding filter must
+// be given the same option.  It has no effect with WithNoHashReset, whose
+// hashers ignore all but the most recent Write.
+func WithHashSeed(seed uint64) Option {
+	return func(ps *params) {
+		binary.BigEndian.PutUint64(ps.seed[:], seed)
+		ps.seeded = true
+	}
+}
+
+// WithFillCounter makes the filter maintain an atomic count of the bits set
+// in its partitions as items are added.  FillRatio and BitsSet then read the
+// counter in constant time, and may be called concurrently with Add, at the
+// cost of testing each bit before setting it.
+func WithFillCounter() Option {
+	return func(ps *params) {
+		ps.fillCounter = true
+	}
+}
+
+// WithTotalBits sizes a filter to m bits in total, split evenly between its
+// partitions, rather than to the number of bits needed to hold n items at the
+// configured error and fill ratios.  The error rate still determines the
+// number of partitions, k, but no longer the size, so the filter reaches the
+// error rate at a number of items that may differ from n.  The size is still
+// subject to WithMaxBits.  With a ScalableFilter, every sub-filter is sized
+// to m bits.
+func WithTotalBits(m uint) Option {
+	return func(ps *params) {
+		ps.totalBits = m
+	}
+}
+
+// WithHashCount sets the number of hash values, and so of partitions, of a
+// filter to k, rather than to ceil(log2(1/e)) for the configured error rate,
+// e.g. to match the geometry of filters built elsewhere, which Merge
+// requires.  If k == 0, it is computed from the error rate.
+func WithHashCount(k uint) Option {
+	return func(ps *params) {
+		ps.hashCount = k
+	}
+}
+
+// hashes returns the number of partitions of a filter with error rate e.
+func (ps *params) hashes(e float64) uint {
+	if ps.hashCount > 0 {
+		return ps.hashCount
+	}
+	return K(e)
+}
+
+// checkRates returns an error unless the error rate e and fill ratio p are in
+// (0, 1), as the options setting them ensure only of their lower bounds.
+func checkRates(e, p float64) error {
+	if !(e > 0 && e < 1) {
+		return fmt.Errorf("bloom: error rate %g out of range (0, 1)", e)
+	}
+	if !(p > 0 && p < 1) {
+		return fmt.Errorf("bloom: fill ratio %g out of range (0, 1)", p)
+	}
+	return nil
+}
+
+// size returns the total number of bits of a filter holding n items with
+// error rate e.
+func (ps *params) size(n uint, e float64) uint {
+	if ps.totalBits > 0 {
+		return ps.totalBits
+	}
+	return M(n, ps.p, e)
+}
+
+// WithMaxBits sets the maximum number of bits a filter may be sized to, so
+// that absurd parameters, such as a mistyped error rate, fail rather than
+// attempt a huge allocation.  Sizing beyond the limit makes NewChecked return
+// an error, and New panic.
+//
+// If limit == 0, defaults to DefaultMaxBits.
+func WithMaxBits(limit uint) Option {
+	max := uint64(limit)
+	if max == 0 {
+		max = DefaultMaxBits
+	}
+
+	return func(ps *params) {
+		ps.maxBits = max
+	}
+}
+
+// WithErrorSchedule sets the error rate of each sub-filter of a
+// ScalableFilter explicitly: sub-filter i uses rates[i], or the last rate once
+// there are more sub-filters than rates.  It overrides the default schedule of
+// e * r^i, where r is the tightening ratio.  Every rate must be in (0, 1),
+// otherwise NewScalable panics.  It has no effect on a Filter.
+func WithErrorSchedule(rates []float64) Option {
+	rates = append([]float64(nil), rates...)
+
+	return func(ps *params) {
+		ps.schedule = rates
+	}
+}
+
+// WithWAL makes the filter append a record of each item passed to Add to w,
+// a write-ahead log from which ReplayWAL can rebuild the filter.  Each record
+// holds the item's length as a big-endian uint32, the item, and a CRC-32 of
+// both.  Items added by hash, as with AddPrehashed, are not logged.  The first
+// error writing to w is reported by WALError, and stops further logging.
+func WithWAL(w io.Writer) Option {
+	return func(ps *params) {
+		ps.wal = w
+	}
+}
+
+// WithCounterBits sets the width in bits of the counters of a CountingFilter:
+// 4, 8 or 16.  Wider counters take more memory but saturate later, at 15,
+// 255 and 65535 respectively.  It defaults to 4, and NewCounting panics on any
+// other width.  It has no effect on a Filter.
+func WithCounterBits(w int) Option {
+	return func(ps *params) {
+		ps.counterBits = w
+	}
+}
+
+// WithExpectedStages preallocates room for n sub-filters in a ScalableFilter,
+// so that growing up to n sub-filters does not reallocate its list of
+// sub-filters.  Only the first sub-filter is created up front.  It has no
+// effect on a Filter.
+func WithExpectedStages(n int) Option {
+	return func(ps *params) {
+		ps.stages = n
+	}
+}
+
+// WithIndexReducer specifies the function mapping the combined hash a + b*i
+// of partition i (see AddPrehashed) to a bit location in [0, bound), where
+// bound is the partition size s.  It defaults to hash modulo bound, and may be
+// replaced by a faster or less biased reduction, such as Lemire's
+// multiply-shift.  fn must be deterministic and return a value below bound;
+// filters that are merged or share encoded data must use the same reducer.
+func WithIndexReducer(fn func(hash uint64, bound uint) uint) Option {
+	return func(ps *params) {
+		ps.reducer = fn
+	}
+}
+
+// unsupported returns the name of the first option set in ps that only a
+// Filter or a ScalableFilter implements, such as WithWAL or WithGuard, or ""
+// if there is none.  The other filters reject such options rather than
+// silently ignoring them.
+func (ps *params) unsupported() string {
+	switch {
+	case ps.wal != nil:
+		return "WithWAL"
+	case ps.onFPR != nil:
+		return "WithFPRCallback"
+	case ps.fillCounter:
+		return "WithFillCounter"
+	case ps.guard != 0:
+		return "WithGuard"
+	case ps.maxBytes != 0:
+		return "WithMaxBytes"
+	case ps.maxFilters > 0:
+		return "WithMaxFilters"
+	case ps.growth != 0:
+		return "WithGrowthFactor"
+	case ps.schedule != nil:
+		return "WithErrorSchedule"
+	case ps.stages > 0:
+		return "WithExpectedStages"
+	}
+	return ""
+}
+
 func withDefault(opt []Option) []Option {
 	return append([]Option{
 		WithHash(nil),
 		WithErrorRate(0),
 		WithFillRatio(0),
+		WithMaxBits(0),
 	}, opt...)
 }
+
+// WithTripleHashing derives the bit locations of an item from three words of
+// its hash rather than two, when the hasher's Sum has at least 16 bytes, as
+// with murmur3.New128.  Partition i then uses bit (a + b*i + c*i*i) mod s,
+// where c folds bytes [8:16] of the Sum, so that two items collide in every
+// partition far less often.  This lowers the false-positive rate of filters
+// with a small error rate, which double hashing alone cannot reach.  It has
+// no effect with shorter hashers, and filters that are merged or share
+// encoded data must agree on it.
+//
+// It is not applied automatically to hashers with a 16-byte Sum, such as md5
+// or murmur3.New128, since that would move the bits of every item for those
+// hashers, so that filters encoded by earlier releases would report items
+// they hold absent.  Nor are a and b taken from the two 64-bit halves of the
+// Sum instead: two items collide in every partition when a and b agree
+// modulo s, however wide they are, whereas an independent third word makes
+// such collisions far rarer.
+func WithTripleHashing() Option {
+	return func(ps *params) {
+		ps.triple = true
+	}
+}
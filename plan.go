@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "math"
+
+// EstimateCapacity returns the largest number of items n for which a filter
+// created with New(n, WithErrorRate(e), WithFillRatio(p)) has a SizeInBytes
+// of at most bytes.  It returns 0 if no such filter fits.
+func EstimateCapacity(bytes uint, e, p float64) uint {
+	k := K(e)
+	if footprint(k, 1) > bytes {
+		return 0
+	}
+
+	// Find the largest partition that fits, then the largest n whose
+	// partitions are no larger.
+	maxS := (bytes - footprint(k, 0)) / (8 * k) * 64
+	n := uint(float64(maxS*k) * (math.Log(p) * math.Log(1-p)) / math.Abs(math.Log(e)))
+	for n > 0 && S(M(n, p, e), k) > maxS {
+		n--
+	}
+
+	return n
+}
+
+// IdealBits returns the total number of bits m with which a filter of k
+// partitions holding n items has a false-positive rate of fpr.  It inverts
+// fpr = (1 - e^(-n/s))^k, where s = m/k is the partition size, and may be used
+// to resize a filter whose measured false-positive rate misses its target.
+func IdealBits(n uint, fpr float64, k uint) uint {
+	s := -float64(n) / math.Log(1-math.Pow(fpr, 1/float64(k)))
+	return uint(math.Ceil(s)) * k
+}
+
+// PlanShards splits a capacity of totalItems into equally-sized filters that
+// each fit in bytesPerShard bytes at error rate e and fill ratio p.  It
+// returns the number of filters and the capacity n of each, or zeros if no
+// filter fits in bytesPerShard.
+func PlanShards(totalItems uint, bytesPerShard uint, e, p float64) (shards int, perShardCapacity uint) {
+	max := EstimateCapacity(bytesPerShard, e, p)
+	if max == 0 || totalItems == 0 {
+		return 0, 0
+	}
+
+	shards = int((totalItems + max - 1) / max)
+	perShardCapacity = (totalItems + uint(shards) - 1) / uint(shards)
+
+	return shards, perShardCapacity
+}
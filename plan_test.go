@@ -0,0 +1,104 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateCapacity(t *testing.T) {
+	t.Parallel()
+
+	for _, bytes := range []uint{4096, 1 << 16, 1 << 20} {
+		for _, e := range []float64{.01, .001, .0001} {
+			n := EstimateCapacity(bytes, e, .5)
+			if n == 0 {
+				t.Fatalf("expected a filter to fit in %d bytes", bytes)
+			}
+
+			if size := New(n, WithErrorRate(e)).SizeInBytes(); size > bytes {
+				t.Errorf("capacity %d at e=%g needs %d bytes, exceeding %d", n, e, size, bytes)
+			}
+
+			if size := New(n+n/100+1, WithErrorRate(e)).SizeInBytes(); size <= bytes {
+				t.Errorf("capacity %d at e=%g underestimates what fits in %d bytes", n, e, bytes)
+			}
+		}
+	}
+
+	if n := EstimateCapacity(10, .001, .5); n != 0 {
+		t.Errorf("expected nothing to fit in 10 bytes, got %d", n)
+	}
+}
+
+func TestPlanShards(t *testing.T) {
+	t.Parallel()
+
+	const total, budget = 10000000, 1 << 20
+
+	shards, n := PlanShards(total, budget, .001, .5)
+	if shards == 0 {
+		t.Fatal("expected a plan")
+	}
+
+	if uint(shards)*n < total {
+		t.Errorf("%d shards of %d items hold fewer than %d", shards, n, total)
+	}
+
+	if size := New(n).SizeInBytes(); size > budget {
+		t.Errorf("shard of %d items needs %d bytes, exceeding %d", n, size, budget)
+	}
+}
+
+// withGeometry returns a filter sized for n items with k partitions of s
+// bits, whatever its error rate.
+func withGeometry(n, k, s uint) *Filter {
+	f := New(n)
+	f.k, f.s, f.m = k, s, k*s
+	f.b = makePartitions(k, s)
+	f.bs = make([]uint, k)
+	return f
+}
+
+func TestIdealBits(t *testing.T) {
+	t.Parallel()
+
+	n := uint(len(web2)) / 2
+	for _, fpr := range []float64{.05, .01, .002} {
+		for _, k := range []uint{4, 7} {
+			m := IdealBits(n, fpr, k)
+			bf := withGeometry(n, k, m/k)
+			if bf.m != m {
+				t.Fatalf("expected %d bits to split into %d partitions", m, k)
+			}
+
+			for _, w := range web2[:n] {
+				bf.Add([]byte(w))
+			}
+
+			fp := 0
+			for _, w := range web2[n:] {
+				if bf.Check([]byte(w)) {
+					fp++
+				}
+			}
+
+			if rate := float64(fp) / float64(len(web2)-int(n)); math.Abs(rate-fpr) > fpr/5 {
+				t.Errorf("fpr=%g k=%d: measured false-positive rate %g", fpr, k, rate)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"hash"
+	"io"
+)
+
+// AddReader adds the item read from r until EOF, as Add would with the bytes
+// read, writing them to the hasher as they are read.  Whether the item is
+// then held in memory depends on the hasher: streaming hashers such as fnv
+// keep constant state, but the default CityHash buffers every byte written
+// until Sum.  If reading fails, it returns the error and leaves the filter
+// unchanged.  With a key transform or a write-ahead log, which need the whole
+// item, the item is read into memory first.
+func (f *Filter) AddReader(r io.Reader) error {
+	if f.wal != nil || f.transform != nil {
+		item, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		f.Add(item)
+		return nil
+	}
+
+	a, b, c, err := f.digestReader(f.h, r)
+	if err != nil {
+		return err
+	}
+
+	f.locations(a, b, c)
+	f.set()
+	return nil
+}
+
+// CheckReader is like Check, but tests the item read from r until EOF,
+// hashing it as it is read.  It returns any error reading r.
+func (f *Filter) CheckReader(r io.Reader) (bool, error) {
+	if f.transform != nil {
+		item, err := io.ReadAll(r)
+		if err != nil {
+			return false, err
+		}
+		return f.Check(item), nil
+	}
+
+	var (
+		bs      [maxStackK]uint
+		a, b, c uint32
+		err     error
+	)
+	f.withHasher(func(h hash.Hash) {
+		a, b, c, err = f.digestReader(h, r)
+	})
+	if err != nil {
+		return false, err
+	}
+	return !f.IsEmpty() && f.testWords(f.scratch(bs[:]), a, b, c), nil
+}
+
+// digestReader is like digest, but copies the item from r to h.  It ignores
+// the key transform.
+func (f *Filter) digestReader(h hash.Hash, r io.Reader) (a, b, c uint32, err error) {
+	f.prime(h)
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, 0, 0, err
+	}
+
+	a, b, c = f.words(h)
+	return a, b, c, nil
+}
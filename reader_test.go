@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAddReader(t *testing.T) {
+	t.Parallel()
+
+	bf := New(uint(len(web2)))
+	br := New(uint(len(web2)))
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+		if err := br.AddReader(iotest.OneByteReader(bytes.NewReader([]byte(w)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bf.Equal(br) || bf.Count() != br.Count() {
+		t.Fatal("expected AddReader to set the bits Add sets")
+	}
+
+	for _, w := range web2a[:10000] {
+		ok, err := br.CheckReader(bytes.NewReader([]byte(w)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != bf.Check([]byte(w)) {
+			t.Fatalf("expected CheckReader to agree with Check for %q", w)
+		}
+	}
+
+	errRead := errors.New("read failed")
+	if err := br.AddReader(iotest.ErrReader(errRead)); !errors.Is(err, errRead) {
+		t.Errorf("expected the read error from AddReader, got %v", err)
+	}
+	if _, err := br.CheckReader(iotest.ErrReader(errRead)); !errors.Is(err, errRead) {
+		t.Errorf("expected the read error from CheckReader, got %v", err)
+	}
+	if br.Count() != 10000 {
+		t.Errorf("expected a failed AddReader not to count, got %d", br.Count())
+	}
+
+	// A key transform reads the whole item first.
+	lower := New(1000, WithKeyTransform(bytes.ToLower))
+	if err := lower.AddReader(bytes.NewReader([]byte("Hello"))); err != nil {
+		t.Fatal(err)
+	}
+	if !lower.Check([]byte("HELLO")) {
+		t.Error("expected AddReader to apply the key transform")
+	}
+}
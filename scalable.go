@@ -16,6 +16,10 @@
 package bloom
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"math"
 )
 
@@ -41,6 +45,13 @@ type ScalableFilter struct {
 
 	// bfs is an array of bloom filters used by the scalable bloom filter
 	bfs []*Filter
+
+	// overloaded records whether an item was added to a full sub-filter
+	// because the filter could not grow.
+	overloaded bool
+
+	// guard, if set, holds every item added, see WithGuard.
+	guard *Filter
 }
 
 // New initializes a new partitioned bloom filter.
@@ -51,7 +62,7 @@ func NewScalable(n uint, opt ...Option) *ScalableFilter {
 	}
 
 	bf := ScalableFilter{
-		opt: opt,
+		opt: copyOptions(opt),
 		n:   n,
 		r:   0.9,
 	}
@@ -60,15 +71,94 @@ func NewScalable(n uint, opt ...Option) *ScalableFilter {
 		option(&bf.params)
 	}
 
+	if err := checkRates(bf.e, bf.p); err != nil {
+		panic(err)
+	}
+
+	for _, e := range bf.schedule {
+		if !(e > 0 && e < 1) {
+			panic("error schedule rate out of range (0, 1)")
+		}
+	}
+
+	if bf.growth != 0 && bf.growth < 1 {
+		panic("growth factor < 1")
+	}
+
+	bf.bfs = bf.stageList()
 	bf.addBloomFilter()
 
+	if bf.params.guard > 0 {
+		bf.guard = New(bf.params.guard, WithErrorRate(bf.e), WithFillRatio(bf.p), sameHashing(&bf.params))
+	}
+
 	return &bf
 }
 
+// Clone returns a deep copy of the filter, including every sub-filter.  As
+// with Filter.Clone, the copy shares the original's hasher, and the two must
+// not be used concurrently, unless the hasher implements HashCloner, and the
+// copy does not write to the original's write-ahead log.
+func (sbf *ScalableFilter) Clone() *ScalableFilter {
+	c := *sbf
+	c.opt = copyOptions(sbf.opt)
+
+	if sbf.wal != nil {
+		c.wal = nil
+		c.opt = append(c.opt, WithWAL(nil))
+	}
+
+	if hc, ok := sbf.h.(HashCloner); ok {
+		c.h = hc.Clone()
+		c.opt = append(c.opt, WithHash(c.h))
+	}
+
+	c.bfs = make([]*Filter, len(sbf.bfs), cap(sbf.bfs))
+	for i, bf := range sbf.bfs {
+		c.bfs[i] = bf.Clone()
+	}
+
+	if sbf.guard != nil {
+		c.guard = sbf.guard.Clone()
+	}
+
+	return &c
+}
+
 func (sbf *ScalableFilter) Reset() {
-	sbf.bfs = []*Filter{}
+	sbf.bfs = sbf.stageList()
 	sbf.c = 0
+	sbf.overloaded = false
 	sbf.addBloomFilter()
+
+	if sbf.guard != nil {
+		sbf.guard.Reset()
+	}
+}
+
+// Clear empties the filter like Reset, but reuses the allocation of the first
+// sub-filter instead of building a new one.  It is intended for recycling
+// filters through a pool.  A first sub-filter sized as a later stage, after
+// RemoveStage, is rebuilt instead, so that the filter matches a fresh one.
+func (sbf *ScalableFilter) Clear() {
+	first := sbf.bfs[0]
+	for i := range sbf.bfs {
+		sbf.bfs[i] = nil
+	}
+	sbf.bfs = sbf.bfs[:0]
+
+	if first.n == sbf.stageSize(0) && first.e == sbf.nextErrorRate() {
+		first.Reset()
+		sbf.bfs = append(sbf.bfs, first)
+	} else {
+		sbf.addBloomFilter()
+	}
+	sbf.c = 0
+	sbf.overloaded = false
+
+	if sbf.guard != nil {
+		sbf.guard.Reset()
+	}
 }
 
 func (sbf *ScalableFilter) EstimatedFillRatio() float64 {
@@ -85,18 +175,114 @@ func (sbf *ScalableFilter) FillRatio() float64 {
 }
 
 func (sbf *ScalableFilter) Add(item []byte) {
+	sbf.current().Add(item)
+	sbf.c++
+
+	if sbf.guard != nil {
+		sbf.guard.Add(item)
+	}
+}
+
+// ctxCheckInterval is the number of items AddAllContext adds between checks
+// of its context.
+const ctxCheckInterval = 100
+
+// AddAllContext adds each of items in order, as Add would, checking ctx
+// every ctxCheckInterval items.  If ctx is done, it stops and returns the
+// number of items added, all of which the filter holds, with ctx.Err().
+func (sbf *ScalableFilter) AddAllContext(ctx context.Context, items [][]byte) (added int, err error) {
+	for i, item := range items {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return i, err
+			}
+		}
+
+		sbf.Add(item)
+	}
+	return len(items), nil
+}
+
+// ErrAtCapacity is returned by ScalableFilter.AddChecked when the filter can
+// no longer grow, within the limits set by WithMaxBytes and WithMaxFilters,
+// and its newest sub-filter is full.
+var ErrAtCapacity = errors.New("bloom: scalable filter at capacity")
+
+// AddChecked adds item like Add, unless the filter has reached a limit set
+// by WithMaxBytes or WithMaxFilters and its newest sub-filter is full, in
+// which case it returns ErrAtCapacity without adding item.  Add would instead
+// overload the newest sub-filter, degrading its error rate; AddChecked lets
+// the caller react, e.g. by rotating to a new filter or shedding load.
+func (sbf *ScalableFilter) AddChecked(item []byte) error {
+	if sbf.bfs[len(sbf.bfs)-1].EstimatedFillRatio() > sbf.p && !sbf.canGrow() {
+		return ErrAtCapacity
+	}
+
+	sbf.Add(item)
+	return nil
+}
+
+// AddNew adds item unless it is already present, reporting whether it was
+// added.  The item is hashed once for both the lookup across all sub-filters
+// and the insertion, and repeats are not counted, so that deduplicating a
+// stream does not grow the filter early.
+func (sbf *ScalableFilter) AddNew(item []byte) bool {
+	last := sbf.bfs[len(sbf.bfs)-1]
+	a, b, c := last.digest(last.h, item)
+
+	if g := sbf.guard; g != nil {
+		if g.locations(a, b, c); g.test() && sbf.find(a, b, c) {
+			return false
+		}
+		g.set()
+	} else if sbf.find(a, b, c) {
+		return false
+	}
+
+	bf := sbf.current()
+	if bf.wal != nil {
+		bf.log(item)
+	}
+
+	bf.locations(a, b, c)
+	bf.set()
+	sbf.c++
+	return true
+}
+
+// find reports whether any sub-filter holds the item with words (a, b, c).
+func (sbf *ScalableFilter) find(a, b, c uint32) bool {
+	for i := len(sbf.bfs) - 1; i >= 0; i-- {
+		bf := sbf.bfs[i]
+		if bf.locations(a, b, c); bf.test() {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the sub-filter to add to, growing the filter first if the
+// newest sub-filter is full.
+func (sbf *ScalableFilter) current() *Filter {
 	i := len(sbf.bfs) - 1
 
 	if sbf.bfs[i].EstimatedFillRatio() > sbf.p {
-		sbf.addBloomFilter()
-		i++
+		if sbf.canGrow() {
+			sbf.addBloomFilter()
+			i++
+		} else {
+			sbf.overloaded = true
+		}
 	}
 
-	sbf.bfs[i].Add(item)
-	sbf.c++
+	return sbf.bfs[i]
 }
 
 func (sbf *ScalableFilter) Check(item []byte) bool {
+	if sbf.guard != nil && !sbf.guard.Check(item) {
+		return false
+	}
+
 	l := len(sbf.bfs)
 	for i := l - 1; i >= 0; i-- {
 		if sbf.bfs[i].Check(item) {
@@ -106,12 +292,233 @@ func (sbf *ScalableFilter) Check(item []byte) bool {
 	return false
 }
 
+// MergeIntoCurrent ORs the bits of bf, which must have the same geometry as
+// the newest sub-filter, into that sub-filter.  The count of bf is added to
+// the filter's, overcounting items present in both so that growth errs on the
+// early side.  It returns ErrIncompatible, leaving the filter unchanged, if
+// the geometries differ or the filter has a guard set with WithGuard, which
+// must hold every item but cannot take the bits of bf, sized differently.
+func (sbf *ScalableFilter) MergeIntoCurrent(bf *Filter) error {
+	if sbf.guard != nil {
+		return ErrIncompatible
+	}
+
+	if err := sbf.bfs[len(sbf.bfs)-1].Merge(bf); err != nil {
+		return err
+	}
+
+	sbf.c += bf.c
+	return nil
+}
+
 func (sbf *ScalableFilter) Count() uint {
 	return sbf.c
 }
 
+// ErrorSchedule returns the error rate assigned to each sub-filter, in
+// creation order.  Sub-filter i is assigned e * r^i, unless a schedule was
+// given with WithErrorSchedule.
+func (sbf *ScalableFilter) ErrorSchedule() []float64 {
+	rates := make([]float64, len(sbf.bfs))
+	for i, bf := range sbf.bfs {
+		rates[i] = bf.ErrorRate()
+	}
+	return rates
+}
+
+// SubFilterFillRatios returns the FillRatio of each sub-filter, in creation
+// order.  Earlier sub-filters are full, while the newest fills as items are
+// added.
+func (sbf *ScalableFilter) SubFilterFillRatios() []float64 {
+	ratios := make([]float64, len(sbf.bfs))
+	for i, bf := range sbf.bfs {
+		ratios[i] = bf.FillRatio()
+	}
+	return ratios
+}
+
+// SubFilters returns the sub-filters in creation order, the last being the
+// one items are added to.  The slice aliases the filter's internal state: it
+// is read-only, and must not be appended to or modified, nor may the
+// sub-filters themselves be.
+func (sbf *ScalableFilter) SubFilters() []*Filter {
+	return sbf.bfs[:len(sbf.bfs):len(sbf.bfs)]
+}
+
+// RemoveStage discards sub-filter i, as numbered by SubFilters, and deducts
+// its count from the filter's.  It is an escape hatch for discarding a stage
+// known to be bad.
+//
+// WARNING: items added only to the removed sub-filter are lost, and Check
+// reports them absent: removing a stage introduces false negatives, which a
+// bloom filter otherwise never has.
+//
+// It returns an error if i is out of range or the filter has a single
+// sub-filter, which must be Reset instead.
+func (sbf *ScalableFilter) RemoveStage(i int) error {
+	if i < 0 || i >= len(sbf.bfs) {
+		return fmt.Errorf("bloom: stage %d out of range [0, %d)", i, len(sbf.bfs))
+	}
+
+	if len(sbf.bfs) == 1 {
+		return errors.New("bloom: cannot remove the only stage")
+	}
+
+	sbf.c -= sbf.bfs[i].c
+	copy(sbf.bfs[i:], sbf.bfs[i+1:])
+	sbf.bfs[len(sbf.bfs)-1] = nil
+	sbf.bfs = sbf.bfs[:len(sbf.bfs)-1]
+
+	return nil
+}
+
+// ScalableHealth is a snapshot of a scalable filter's load.
+type ScalableHealth struct {
+	// Count is the number of items added.
+	Count uint `json:"count"`
+
+	// Stages is the number of sub-filters.
+	Stages int `json:"stages"`
+
+	// EstimatedFalsePositiveRate is the probability that an item absent
+	// from every sub-filter is reported present by any of them.
+	EstimatedFalsePositiveRate float64 `json:"estimated_false_positive_rate"`
+
+	// SizeInBytes is the SizeInBytes of the filter.
+	SizeInBytes uint `json:"size_in_bytes"`
+
+	// AtLimit reports whether the filter can no longer grow within the
+	// limits set by WithMaxBytes and WithMaxFilters, so that its newest
+	// sub-filter takes all further items and its error rate degrades once
+	// that is full.
+	AtLimit bool `json:"at_limit"`
+}
+
+// Health returns a snapshot of the filter's load.
+func (sbf *ScalableFilter) Health() ScalableHealth {
+	h := ScalableHealth{
+		Count:       sbf.c,
+		Stages:      len(sbf.bfs),
+		SizeInBytes: sbf.SizeInBytes(),
+		AtLimit:     !sbf.canGrow(),
+	}
+
+	// An absent item is reported present unless every sub-filter rejects it.
+	miss := 1.0
+	for _, bf := range sbf.bfs {
+		miss *= 1 - bf.EstimatedFalsePositiveRate()
+	}
+	h.EstimatedFalsePositiveRate = 1 - miss
+
+	return h
+}
+
+// SizeInBytes returns the memory used by the filter: the total SizeInBytes of
+// its sub-filters and guard, which WithMaxBytes bounds.
+func (sbf *ScalableFilter) SizeInBytes() uint {
+	var size uint
+	for _, bf := range sbf.bfs {
+		size += bf.SizeInBytes()
+	}
+
+	if sbf.guard != nil {
+		size += sbf.guard.SizeInBytes()
+	}
+	return size
+}
+
+// RemainingCapacity estimates how many more items can be added before the
+// filter grows a new sub-filter.  It is derived from the newest sub-filter's
+// count and the count at which its estimated fill ratio exceeds the target p.
+func (sbf *ScalableFilter) RemainingCapacity() int {
+	bf := sbf.bfs[len(sbf.bfs)-1]
+	return int(bf.threshold(sbf.p)) + 1 - int(bf.c)
+}
+
+// Overloaded reports whether an item has been added past the capacity of the
+// newest sub-filter because the filter could not grow, within the limits set
+// by WithMaxBytes and WithMaxFilters, since it was created or reset.  The
+// filter's error rate then exceeds its target.
+func (sbf *ScalableFilter) Overloaded() bool {
+	return sbf.overloaded
+}
+
+// canGrow reports whether adding a sub-filter keeps the filter within its
+// configured limits.
+func (sbf *ScalableFilter) canGrow() bool {
+	if sbf.maxFilters > 0 && len(sbf.bfs) >= sbf.maxFilters {
+		return false
+	}
+
+	if sbf.maxBytes == 0 {
+		return true
+	}
+
+	size := sbf.SizeInBytes()
+	e := sbf.nextErrorRate()
+	k := sbf.hashes(e)
+	return size+footprint(k, S(sbf.size(sbf.stageSize(len(sbf.bfs)), e), k)) <= sbf.maxBytes
+}
+
 func (sbf *ScalableFilter) addBloomFilter() {
-	e := sbf.e * math.Pow(float64(sbf.r), float64(len(sbf.bfs)))
-	bf := New(sbf.n, append(sbf.opt, WithErrorRate(e))...)
+	bf := New(sbf.stageSize(len(sbf.bfs)), append(sbf.opt, WithErrorRate(sbf.nextErrorRate()))...)
 	sbf.bfs = append(sbf.bfs, bf)
 }
+
+// stageSize returns the number of items sub-filter i is sized for.
+func (sbf *ScalableFilter) stageSize(i int) uint {
+	if sbf.growth <= 1 {
+		return sbf.n
+	}
+	return uint(math.Ceil(float64(sbf.n) * math.Pow(sbf.growth, float64(i))))
+}
+
+// stageList returns an empty list of sub-filters, with room for the number
+// of stages expected by WithExpectedStages.
+func (sbf *ScalableFilter) stageList() []*Filter {
+	if sbf.stages > 0 {
+		return make([]*Filter, 0, sbf.stages)
+	}
+	return []*Filter{}
+}
+
+// sameHashing returns an option giving a filter the hasher and hashing
+// options of ps, such as WithHashSeed and WithTransform, so that it maps
+// items to the same words and bit locations, without its other options: a
+// guard must neither write to the write-ahead log nor count against
+// WithTotalBits.
+func sameHashing(ps *params) Option {
+	return func(dst *params) {
+		dst.h, dst.hashers = ps.h, ps.hashers
+		if ps.hashers != nil {
+			dst.h = ps.hashers.New().(hash.Hash)
+		}
+
+		dst.transform = ps.transform
+		dst.reducer = ps.reducer
+		dst.noReset = ps.noReset
+		dst.triple = ps.triple
+		dst.seed, dst.seeded = ps.seed, ps.seeded
+	}
+}
+
+// copyOptions returns a copy of opt with no spare capacity, so that appending
+// to it never writes to the caller's backing array.
+func copyOptions(opt []Option) []Option {
+	c := make([]Option, len(opt))
+	copy(c, opt)
+	return c
+}
+
+// nextErrorRate returns the error rate of the next sub-filter.
+func (sbf *ScalableFilter) nextErrorRate() float64 {
+	if l := len(sbf.schedule); l > 0 {
+		i := len(sbf.bfs)
+		if i >= l {
+			i = l - 1
+		}
+		return sbf.schedule[i]
+	}
+
+	return sbf.e * math.Pow(float64(sbf.r), float64(len(sbf.bfs)))
+}
@@ -16,11 +16,14 @@
 package bloom
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"hash"
 	"hash/crc64"
 	"hash/fnv"
+	"math"
 	"testing"
 
 	"github.com/spaolacci/murmur3"
@@ -178,3 +181,849 @@ func BenchmarkScalableSha1(b *testing.B) {
 
 	b.StopTimer()
 }
+
+func TestScalableRemainingCapacity(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	last := bf.RemainingCapacity()
+	if last <= 0 {
+		t.Fatalf("expected positive capacity for a fresh filter, got %d", last)
+	}
+
+	grown := false
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+
+		r := bf.RemainingCapacity()
+		switch {
+		case r > last:
+			if last != 0 {
+				t.Fatalf("filter grew with %d capacity remaining", last)
+			}
+			grown = true
+		case r != last-1:
+			t.Fatalf("expected capacity %d after add, got %d", last-1, r)
+		}
+		last = r
+	}
+
+	if !grown {
+		t.Error("expected capacity to reset upward after a growth event")
+	}
+}
+
+func TestScalableClear(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	if len(bf.bfs) < 2 {
+		t.Fatalf("expected the filter to grow, got %d sub-filters", len(bf.bfs))
+	}
+
+	bf.Clear()
+
+	fresh := NewScalable(1000)
+	if len(bf.bfs) != 1 || bf.Count() != 0 || bf.RemainingCapacity() != fresh.RemainingCapacity() {
+		t.Fatal("expected a cleared filter to match a fresh one")
+	}
+
+	for _, w := range web2[10000:20000] {
+		bf.Add([]byte(w))
+		fresh.Add([]byte(w))
+	}
+
+	if len(bf.bfs) != len(fresh.bfs) {
+		t.Fatalf("expected %d sub-filters, got %d", len(fresh.bfs), len(bf.bfs))
+	}
+
+	for i := range bf.bfs {
+		for j := range bf.bfs[i].b {
+			if !bf.bfs[i].b[j].Equal(fresh.bfs[i].b[j]) {
+				t.Fatalf("sub-filter %d partition %d differs from a fresh filter", i, j)
+			}
+		}
+	}
+
+	// After removing the first stage, Clear rebuilds it rather than keeping
+	// the stage that took its place.
+	if err := bf.RemoveStage(0); err != nil {
+		t.Fatal(err)
+	}
+	bf.Clear()
+
+	first, want := bf.bfs[0], NewScalable(1000).bfs[0]
+	if first.n != want.n || first.e != want.e || first.m != want.m {
+		t.Errorf("expected a first stage of %d items at %g, got %d at %g", want.n, want.e, first.n, first.e)
+	}
+}
+
+func BenchmarkScalableClear(b *testing.B) {
+	benchmarkScalableRecycle(b, (*ScalableFilter).Clear)
+}
+
+func BenchmarkScalableReset(b *testing.B) {
+	benchmarkScalableRecycle(b, (*ScalableFilter).Reset)
+}
+
+func benchmarkScalableRecycle(b *testing.B, recycle func(*ScalableFilter)) {
+	bf := NewScalable(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, w := range web2[:1000] {
+			bf.Add([]byte(w))
+		}
+		recycle(bf)
+	}
+}
+
+func TestScalableMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	first := NewScalable(1000).bfs[0].SizeInBytes()
+	limit := 3*first + first/2
+
+	bf := NewScalable(1000, WithMaxBytes(limit))
+	for _, w := range web2[:50000] {
+		bf.Add([]byte(w))
+	}
+
+	var size uint
+	for _, f := range bf.bfs {
+		size += f.SizeInBytes()
+	}
+
+	if size > limit {
+		t.Fatalf("filter grew to %d bytes, beyond the %d limit", size, limit)
+	}
+
+	if len(bf.bfs) < 2 {
+		t.Fatalf("expected the filter to grow up to the limit, got %d sub-filters", len(bf.bfs))
+	}
+
+	for _, w := range web2[:50000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	if bf.Count() != 50000 {
+		t.Errorf("expected count 50000, got %d", bf.Count())
+	}
+}
+
+func TestScalableClone(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for _, w := range web2[:1500] {
+		bf.Add([]byte(w))
+	}
+
+	stages := len(bf.bfs)
+	c := bf.Clone()
+
+	for _, w := range web2[1500:10000] {
+		c.Add([]byte(w))
+	}
+
+	if len(c.bfs) <= stages {
+		t.Fatal("expected the clone to grow")
+	}
+
+	if len(bf.bfs) != stages || bf.Count() != 1500 {
+		t.Fatalf("expected the original to keep %d sub-filters and 1500 items, got %d and %d",
+			stages, len(bf.bfs), bf.Count())
+	}
+
+	for i := range bf.bfs {
+		if bf.bfs[i] == c.bfs[i] || bf.bfs[i].b[0] == c.bfs[i].b[0] {
+			t.Fatalf("sub-filter %d is shared with the clone", i)
+		}
+	}
+
+	for _, w := range web2[:10000] {
+		if !c.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present in the clone", w)
+		}
+	}
+
+	fp := 0
+	for _, w := range web2[1500:10000] {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	if fp > 100 {
+		t.Errorf("%d items added to the clone found in the original", fp)
+	}
+}
+
+func TestScalableErrorSchedule(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000, WithErrorRate(.01))
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	rates := bf.ErrorSchedule()
+	if len(rates) < 3 {
+		t.Fatalf("expected at least 3 sub-filters, got %d", len(rates))
+	}
+
+	for i, e := range rates {
+		if want := .01 * math.Pow(.9, float64(i)); math.Abs(e-want) > 1e-9 {
+			t.Errorf("sub-filter %d: expected error rate %g, got %g", i, want, e)
+		}
+	}
+}
+
+func TestScalableWithErrorSchedule(t *testing.T) {
+	t.Parallel()
+
+	schedule := []float64{.01, .005, .001}
+
+	bf := NewScalable(1000, WithErrorSchedule(schedule))
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	rates := bf.ErrorSchedule()
+	if len(rates) <= len(schedule) {
+		t.Fatalf("expected more than %d sub-filters, got %d", len(schedule), len(rates))
+	}
+
+	for i, e := range rates {
+		want := schedule[len(schedule)-1]
+		if i < len(schedule) {
+			want = schedule[i]
+		}
+
+		if e != want {
+			t.Errorf("sub-filter %d: expected error rate %g, got %g", i, want, e)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a rate outside (0, 1) to panic")
+		}
+	}()
+	NewScalable(1000, WithErrorSchedule([]float64{.01, 1}))
+}
+
+func TestScalableMergeIntoCurrent(t *testing.T) {
+	t.Parallel()
+
+	sbf := NewScalable(10000)
+	for _, w := range web2[:1000] {
+		sbf.Add([]byte(w))
+	}
+
+	bf := New(10000)
+	for _, w := range web2[1000:2000] {
+		bf.Add([]byte(w))
+	}
+
+	if err := sbf.MergeIntoCurrent(bf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range web2[:2000] {
+		if !sbf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	if sbf.Count() != 2000 {
+		t.Errorf("expected count 2000, got %d", sbf.Count())
+	}
+
+	if err := sbf.MergeIntoCurrent(New(1000)); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestScalableSubFilters(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	subs := bf.SubFilters()
+	if len(subs) < 2 {
+		t.Fatalf("expected the filter to grow, got %d sub-filters", len(subs))
+	}
+
+	var c uint
+	for _, f := range subs {
+		c += f.Count()
+	}
+	if c != bf.Count() {
+		t.Errorf("expected sub-filter counts to sum to %d, got %d", bf.Count(), c)
+	}
+
+	for _, w := range web2[:10000] {
+		found := false
+		for _, f := range subs {
+			if f.Check([]byte(w)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("expected %q to be present in a sub-filter", w)
+		}
+	}
+}
+
+func TestScalableAddNew(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for i := 0; i < 3; i++ {
+		for j, w := range web2[:5000] {
+			added := bf.AddNew([]byte(w))
+			if i > 0 && added {
+				t.Fatalf("expected repeat %d of %q not to be added", i, w)
+			}
+			if i == 0 && !added && j < 100 {
+				t.Fatalf("expected %q to be added", w)
+			}
+		}
+	}
+
+	if len(bf.bfs) < 2 {
+		t.Fatalf("expected the filter to grow, got %d sub-filters", len(bf.bfs))
+	}
+
+	// Items mistaken for earlier ones are not added, so the count may fall
+	// short of the distinct items by the false positives.
+	if c := bf.Count(); c > 5000 || c < 4950 {
+		t.Errorf("expected count near 5000, got %d", c)
+	}
+
+	for _, w := range web2[:5000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+}
+
+func TestScalableExpectedStages(t *testing.T) {
+	t.Parallel()
+
+	const stages = 4
+
+	bf := NewScalable(100, WithExpectedStages(stages))
+	if cap(bf.bfs) != stages {
+		t.Fatalf("expected room for %d sub-filters, got %d", stages, cap(bf.bfs))
+	}
+
+	first := &bf.bfs[0]
+	for _, w := range web2 {
+		bf.Add([]byte(w))
+
+		if len(bf.bfs) <= stages && &bf.bfs[0] != first {
+			t.Fatalf("sub-filter list reallocated with %d sub-filters", len(bf.bfs))
+		}
+
+		if len(bf.bfs) > stages {
+			break
+		}
+	}
+
+	if len(bf.bfs) <= stages {
+		t.Fatalf("expected more than %d sub-filters, got %d", stages, len(bf.bfs))
+	}
+
+	bf.Reset()
+	if cap(bf.bfs) != stages {
+		t.Errorf("expected Reset to keep room for %d sub-filters, got %d", stages, cap(bf.bfs))
+	}
+}
+
+func TestScalableRemoveStage(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+
+	var stages [][]string
+	for _, w := range web2[:5000] {
+		bf.Add([]byte(w))
+
+		i := len(bf.bfs) - 1
+		if i == len(stages) {
+			stages = append(stages, nil)
+		}
+		stages[i] = append(stages[i], w)
+	}
+
+	if len(bf.bfs) < 3 {
+		t.Fatalf("expected at least 3 sub-filters, got %d", len(bf.bfs))
+	}
+
+	n := len(bf.bfs)
+	if err := bf.RemoveStage(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bf.bfs) != n-1 {
+		t.Fatalf("expected %d sub-filters, got %d", n-1, len(bf.bfs))
+	}
+
+	if want := uint(5000 - len(stages[1])); bf.Count() != want {
+		t.Errorf("expected count %d, got %d", want, bf.Count())
+	}
+
+	for i, items := range stages {
+		missing := 0
+		for _, w := range items {
+			if !bf.Check([]byte(w)) {
+				missing++
+			}
+		}
+
+		switch {
+		case i == 1 && missing < len(items)*9/10:
+			t.Errorf("expected items of the removed stage to be absent, %d of %d missing", missing, len(items))
+		case i != 1 && missing != 0:
+			t.Errorf("stage %d: %d items missing", i, missing)
+		}
+	}
+
+	if err := bf.RemoveStage(n); err == nil {
+		t.Error("expected an error for an out of range stage")
+	}
+
+	if err := NewScalable(1000).RemoveStage(0); err == nil {
+		t.Error("expected an error removing the only stage")
+	}
+}
+
+func TestScalableHealth(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	h := bf.Health()
+	if h.Count != 10000 || h.Stages != len(bf.bfs) || h.AtLimit {
+		t.Fatalf("unexpected health %+v for %d sub-filters", h, len(bf.bfs))
+	}
+
+	var size uint
+	for _, f := range bf.bfs {
+		size += f.SizeInBytes()
+	}
+	if h.SizeInBytes != size {
+		t.Errorf("expected size %d, got %d", size, h.SizeInBytes)
+	}
+
+	fp := 0
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+	}
+
+	// Small partitions run somewhat above the estimate.
+	if rate := float64(fp) / float64(len(web2a)); rate < h.EstimatedFalsePositiveRate/3 || rate > h.EstimatedFalsePositiveRate*3 {
+		t.Errorf("estimated false-positive rate %g, measured %g", h.EstimatedFalsePositiveRate, rate)
+	}
+
+	limited := NewScalable(1000, WithMaxBytes(NewScalable(1000).bfs[0].SizeInBytes()))
+	if !limited.Health().AtLimit {
+		t.Error("expected a filter limited to one sub-filter to be at its limit")
+	}
+}
+
+func TestScalableAddChecked(t *testing.T) {
+	t.Parallel()
+
+	first := NewScalable(1000).bfs[0].SizeInBytes()
+	bf := NewScalable(1000, WithMaxBytes(2*first+first/2))
+
+	added := 0
+	for _, w := range web2[:10000] {
+		if err := bf.AddChecked([]byte(w)); err != nil {
+			if err != ErrAtCapacity {
+				t.Fatal(err)
+			}
+			break
+		}
+		added++
+	}
+
+	if added < 1500 || added == 10000 {
+		t.Fatalf("expected the filter to reach capacity after growing, added %d items", added)
+	}
+
+	if len(bf.bfs) != 2 || bf.Count() != uint(added) {
+		t.Fatalf("expected 2 sub-filters and %d items, got %d and %d", added, len(bf.bfs), bf.Count())
+	}
+
+	if err := bf.AddChecked([]byte(web2[added])); err != ErrAtCapacity {
+		t.Errorf("expected ErrAtCapacity, got %v", err)
+	}
+
+	for _, w := range web2[:added] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+}
+
+func TestScalableSizeInBytes(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	before := bf.SizeInBytes()
+	if before != bf.bfs[0].SizeInBytes() {
+		t.Fatalf("expected the size of the only sub-filter, %d, got %d", bf.bfs[0].SizeInBytes(), before)
+	}
+
+	for _, w := range web2 {
+		if bf.Add([]byte(w)); len(bf.bfs) == 2 {
+			break
+		}
+	}
+
+	// The second sub-filter has a tighter error rate, so is a little larger.
+	if after := bf.SizeInBytes(); after < 2*before || after > 5*before/2 {
+		t.Errorf("expected the size to roughly double from %d, got %d", before, after)
+	}
+}
+
+// cancelAfter is a context that is cancelled once Err has been called n
+// times.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestScalableAddAllContext(t *testing.T) {
+	t.Parallel()
+
+	items := make([][]byte, 5000)
+	for i := range items {
+		items[i] = []byte(web2[i])
+	}
+
+	bf := NewScalable(1000)
+	added, err := bf.AddAllContext(&cancelAfter{context.Background(), 1000 / ctxCheckInterval}, items)
+	if err != context.Canceled || added != 1000 {
+		t.Fatalf("expected 1000 items added before cancellation, got %d, %v", added, err)
+	}
+
+	if bf.Count() != 1000 {
+		t.Errorf("expected count 1000, got %d", bf.Count())
+	}
+
+	for _, item := range items[:1000] {
+		if !bf.Check(item) {
+			t.Fatalf("expected %q to be found", item)
+		}
+	}
+
+	added, err = NewScalable(1000).AddAllContext(context.Background(), items)
+	if err != nil || added != len(items) {
+		t.Errorf("expected all %d items added, got %d, %v", len(items), added, err)
+	}
+}
+
+func TestScalableMaxFilters(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000, WithMaxFilters(3))
+	for _, w := range web2[:2000] {
+		bf.Add([]byte(w))
+	}
+
+	if bf.Overloaded() {
+		t.Fatal("expected the filter not to be overloaded before reaching its limit")
+	}
+
+	for _, w := range web2[2000:20000] {
+		bf.Add([]byte(w))
+	}
+
+	size := bf.SizeInBytes()
+	for _, w := range web2[20000:30000] {
+		bf.Add([]byte(w))
+	}
+
+	if len(bf.bfs) != 3 || bf.SizeInBytes() != size {
+		t.Fatalf("expected growth to stop at 3 sub-filters of %d bytes, got %d of %d", size, len(bf.bfs), bf.SizeInBytes())
+	}
+
+	if !bf.Overloaded() || !bf.Health().AtLimit {
+		t.Error("expected the filter to be overloaded and at its limit")
+	}
+
+	if err := bf.AddChecked([]byte("one more")); err != ErrAtCapacity {
+		t.Errorf("expected ErrAtCapacity, got %v", err)
+	}
+
+	for _, w := range web2[:30000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be present", w)
+		}
+	}
+
+	data, err := bf.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g ScalableFilter
+	if err := g.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.maxFilters != 3 || g.canGrow() {
+		t.Errorf("expected the decoded filter to keep its limit of 3 sub-filters, got %d", g.maxFilters)
+	}
+
+	if bf.Reset(); bf.Overloaded() {
+		t.Error("expected Reset to clear the overloaded flag")
+	}
+}
+
+func TestScalableSubFilterFillRatios(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000)
+	for _, w := range web2 {
+		if len(bf.bfs) == 3 {
+			break
+		}
+		bf.Add([]byte(w))
+	}
+
+	ratios := bf.SubFilterFillRatios()
+	if len(ratios) != 3 {
+		t.Fatalf("expected 3 fill ratios, got %d", len(ratios))
+	}
+
+	for i, r := range ratios[:2] {
+		if r < ratios[2] {
+			t.Errorf("expected sub-filter %d, at %g, to be fuller than the newest, at %g", i, r, ratios[2])
+		}
+	}
+}
+
+func TestScalableGrowthFactor(t *testing.T) {
+	t.Parallel()
+
+	keys := SyntheticKeys(1000000, 4)
+
+	flat := NewScalable(10000)
+	grown := NewScalable(10000, WithGrowthFactor(2))
+	for _, k := range keys {
+		flat.Add([]byte(k))
+		grown.Add([]byte(k))
+	}
+
+	if len(grown.bfs) >= len(flat.bfs)/4 {
+		t.Fatalf("expected far fewer than %d sub-filters with a growth factor of 2, got %d", len(flat.bfs), len(grown.bfs))
+	}
+
+	for i, bf := range grown.bfs {
+		if want := uint(10000) << uint(i); bf.n != want {
+			t.Errorf("sub-filter %d: expected capacity %d, got %d", i, want, bf.n)
+		}
+	}
+
+	// The error rates of the sub-filters sum to at most e / (1 - r).
+	target := grown.e / (1 - float64(grown.r))
+	fp := 0
+	for _, w := range web2[:100000] {
+		if grown.Check([]byte(w)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 100000; rate > target {
+		t.Errorf("expected a false-positive rate under %g, got %g", target, rate)
+	}
+
+	data, err := grown.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g ScalableFilter
+	if err := g.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+	if g.growth != 2 || len(g.bfs) != len(grown.bfs) {
+		t.Errorf("expected the decoded filter to keep its growth factor of 2, got %g", g.growth)
+	}
+
+	// Sub-filters no longer match the growth schedule by index once a stage
+	// is removed, but still round-trip.
+	if err := grown.RemoveStage(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err = grown.GobEncode(); err != nil {
+		t.Fatal(err)
+	}
+
+	g = ScalableFilter{}
+	if err := g.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.bfs) != len(grown.bfs) || g.Count() != grown.Count() {
+		t.Fatalf("expected %d items in %d sub-filters, got %d in %d", grown.Count(), len(grown.bfs), g.Count(), len(g.bfs))
+	}
+	for i := range g.bfs {
+		if g.bfs[i].n != grown.bfs[i].n || !g.bfs[i].Equal(grown.bfs[i]) {
+			t.Fatalf("sub-filter %d differs after decoding", i)
+		}
+	}
+}
+
+func TestScalableGuard(t *testing.T) {
+	t.Parallel()
+
+	bf := NewScalable(1000, WithGuard(50000))
+	plain := NewScalable(1000)
+	for _, w := range web2[:50000] {
+		bf.Add([]byte(w))
+		plain.Add([]byte(w))
+	}
+
+	if len(bf.bfs) < 10 {
+		t.Fatalf("expected many sub-filters, got %d", len(bf.bfs))
+	}
+
+	for _, w := range web2[:50000] {
+		if !bf.Check([]byte(w)) {
+			t.Fatalf("expected %q to be found", w)
+		}
+	}
+
+	// The guard only removes false positives.
+	var fp, plainFP int
+	for _, w := range web2a {
+		if bf.Check([]byte(w)) {
+			fp++
+		}
+		if plain.Check([]byte(w)) {
+			plainFP++
+		}
+	}
+	if fp > plainFP {
+		t.Errorf("expected no more false positives than %d with a guard, got %d", plainFP, fp)
+	}
+
+	if bf.SizeInBytes() != plain.SizeInBytes()+bf.guard.SizeInBytes() {
+		t.Errorf("expected the guard to be included in the size")
+	}
+
+	// AddNew also keeps the guard up to date.
+	if !bf.AddNew([]byte("fresh")) || bf.AddNew([]byte("fresh")) || !bf.guard.Check([]byte("fresh")) {
+		t.Error("expected AddNew to add a new item once, through the guard")
+	}
+
+	data, err := bf.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g ScalableFilter
+	if err := g.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+	if g.guard == nil || !g.guard.Equal(bf.guard) {
+		t.Error("expected the guard to survive encoding")
+	}
+
+	// Merging would leave items outside the guard, which reports them
+	// absent, so it is refused.
+	last := bf.bfs[len(bf.bfs)-1]
+	other := New(last.n, WithErrorRate(last.e))
+	other.Add([]byte("merged"))
+	if err := bf.MergeIntoCurrent(other); err != ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+	if bf.guard == nil || bf.Check([]byte("merged")) {
+		t.Error("expected a refused merge to leave the filter unchanged")
+	}
+}
+
+func TestScalableGuardOptions(t *testing.T) {
+	t.Parallel()
+
+	// The guard hashes like the sub-filters, but neither logs items nor takes
+	// its size from WithTotalBits.
+	var log bytes.Buffer
+
+	bf := NewScalable(1000, WithGuard(50000), WithWAL(&log), WithTotalBits(1<<16), WithHashSeed(7))
+	bf.Add([]byte("abc"))
+	if log.Len() != 4+3+4 {
+		t.Errorf("expected a single record of 11 bytes, got %d bytes", log.Len())
+	}
+
+	if want := New(50000, WithErrorRate(bf.e)).m; bf.guard.m != want {
+		t.Errorf("expected the guard to be sized for its own capacity, %d bits, got %d", want, bf.guard.m)
+	}
+
+	for _, w := range web2[:5000] {
+		bf.AddNew([]byte(w))
+	}
+	for _, w := range web2[:5000] {
+		if !bf.guard.Check([]byte(w)) {
+			t.Fatalf("expected the guard to hold %q", w)
+		}
+	}
+}
+
+func BenchmarkScalableCheckMiss(b *testing.B) {
+	b.Run("plain", func(b *testing.B) {
+		benchmarkScalableCheckMiss(b)
+	})
+	b.Run("guard", func(b *testing.B) {
+		benchmarkScalableCheckMiss(b, WithGuard(60000))
+	})
+}
+
+// benchmarkScalableCheckMiss checks absent items against a filter of about 50
+// sub-filters.
+func benchmarkScalableCheckMiss(b *testing.B, opt ...Option) {
+	bf := NewScalable(1000, opt...)
+	for _, w := range web2 {
+		if len(bf.bfs) == 50 {
+			break
+		}
+		bf.Add([]byte(w))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		bf.Check([]byte(web2a[i%len(web2a)]))
+	}
+}
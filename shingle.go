@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+// AddShingled adds every size-byte substring, or shingle, of data to the
+// filter, so that CheckSimilar can detect near-duplicates of it.  Data
+// shorter than size is added whole.
+//
+// Each call adds len(data)-size+1 items, which must be accounted for when
+// sizing the filter.  Small shingles match across unrelated data more often,
+// raising the similarity of everything; large shingles are more specific, but
+// a single changed byte invalidates up to size of them.  Sizes of 4 to 8
+// bytes suit text.
+func (f *Filter) AddShingled(data []byte, size int) {
+	shingles(data, size, func(s []byte) bool {
+		f.Add(s)
+		return true
+	})
+}
+
+// CheckSimilar returns true if more than the threshold fraction of the
+// size-byte shingles of data are in the filter, as added by AddShingled with
+// the same size.  It stops checking shingles as soon as the outcome is known.
+func (f *Filter) CheckSimilar(data []byte, size int, threshold float64) bool {
+	total := 1
+	if size > 0 && len(data) > size {
+		total = len(data) - size + 1
+	}
+	need := threshold * float64(total)
+
+	found, left := 0, total
+	shingles(data, size, func(s []byte) bool {
+		if f.Check(s) {
+			found++
+		}
+		left--
+
+		// Stop once the threshold is exceeded, or out of reach.
+		return float64(found) <= need && float64(found+left) > need
+	})
+
+	return float64(found) > need
+}
+
+// shingles calls fn with every size-byte substring of data, or with data if it
+// is shorter, until fn returns false.
+func shingles(data []byte, size int, fn func([]byte) bool) {
+	if size <= 0 || len(data) <= size {
+		fn(data)
+		return
+	}
+
+	for i := 0; i+size <= len(data); i++ {
+		if !fn(data[i : i+size]) {
+			return
+		}
+	}
+}
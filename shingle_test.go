@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestShingled(t *testing.T) {
+	t.Parallel()
+
+	bf := New(10000)
+	bf.AddShingled([]byte("2020-06-01 12:00:00 INFO connection accepted from 10.0.0.1 port 8545"), 6)
+
+	similar := []byte("2020-06-01 12:00:00 INFO connection rejected from 10.0.0.1 port 8545")
+	if !bf.CheckSimilar(similar, 6, .7) {
+		t.Error("expected lines differing in one token to be similar")
+	}
+
+	unrelated := []byte("panic: runtime error: index out of range [3] with length 3")
+	if bf.CheckSimilar(unrelated, 6, .1) {
+		t.Error("expected unrelated lines not to be similar")
+	}
+
+	short := []byte("abc")
+	bf.AddShingled(short, 6)
+	if !bf.CheckSimilar(short, 6, .99) {
+		t.Error("expected data shorter than the shingle size to be added whole")
+	}
+}
+
+func TestCheckSimilarEarlyExit(t *testing.T) {
+	t.Parallel()
+
+	checks := 0
+	bf := New(10000, WithKeyTransform(func(b []byte) []byte {
+		checks++
+		return b
+	}))
+
+	data := []byte("2020-06-01 12:00:00 INFO connection accepted")
+	bf.AddShingled(data, 6)
+
+	// Any shingle found exceeds a threshold of 0, and any missing one puts
+	// a threshold of 1 out of reach.
+	checks = 0
+	if !bf.CheckSimilar(data, 6, 0) || checks != 1 {
+		t.Errorf("expected a single check to exceed a threshold of 0, got %d", checks)
+	}
+
+	checks = 0
+	if bf.CheckSimilar([]byte("panic: runtime error: index out of range"), 6, 1) || checks != 1 {
+		t.Errorf("expected a single miss to rule out a threshold of 1, got %d checks", checks)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"hash"
+	"io"
+	"unsafe"
+)
+
+// AddString adds s to the filter, as Add([]byte(s)) would, but without
+// converting s to a byte slice, so that with a hasher implementing
+// hash.Hash64, such as the default or fnv, it does not allocate.
+func (f *Filter) AddString(s string) {
+	if f.wal != nil || f.transform != nil {
+		f.Add([]byte(s))
+		return
+	}
+
+	f.locations(f.digestString(f.h, s))
+	f.set()
+}
+
+// CheckString is like Check, but takes a string, which it hashes without
+// converting it to a byte slice.
+func (f *Filter) CheckString(s string) bool {
+	if f.transform != nil {
+		return f.Check([]byte(s))
+	}
+
+	if f.IsEmpty() {
+		return false
+	}
+
+	var (
+		bs      [maxStackK]uint
+		a, b, c uint32
+	)
+	f.withHasher(func(h hash.Hash) {
+		a, b, c = f.digestString(h, s)
+	})
+	return f.testWords(f.scratch(bs[:]), a, b, c)
+}
+
+// digestString is like digest, but writes s to h with WriteString if h
+// implements io.StringWriter, and otherwise writes the bytes of s in place.
+// It ignores the key transform, which may modify its argument.
+func (f *Filter) digestString(h hash.Hash, s string) (a, b, c uint32) {
+	f.prime(h)
+	if sw, ok := h.(io.StringWriter); ok {
+		sw.WriteString(s)
+	} else {
+		h.Write(stringBytes(s))
+	}
+	return f.words(h)
+}
+
+// stringBytes returns the bytes of s without copying them.  They must not be
+// modified; hash.Hash forbids Write from doing so.
+func stringBytes(s string) []byte {
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)}))
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"hash"
+	"hash/fnv"
+	"testing"
+)
+
+func TestAddString(t *testing.T) {
+	t.Parallel()
+
+	fnvFunc := WithHashFunc(func() hash.Hash { return fnv.New64() })
+
+	bf := New(uint(len(web2)), fnvFunc)
+	for _, w := range web2 {
+		bf.AddString(w)
+	}
+
+	want := New(uint(len(web2)), fnvFunc)
+	for _, w := range web2 {
+		want.Add([]byte(w))
+	}
+
+	for i := range bf.b {
+		if !bf.b[i].Equal(want.b[i]) {
+			t.Fatalf("partition %d differs from adding byte slices", i)
+		}
+	}
+
+	for _, w := range web2a {
+		if bf.CheckString(w) != want.Check([]byte(w)) {
+			t.Fatalf("expected CheckString to agree with Check on %q", w)
+		}
+	}
+
+}
+
+// TestAddStringAllocs is not parallel, as AllocsPerRun requires.
+func TestAddStringAllocs(t *testing.T) {
+	for name, bf := range map[string]*Filter{
+		"default": New(1000),
+		"fnv":     New(1000, WithHashFunc(func() hash.Hash { return fnv.New64() })),
+	} {
+		if allocs := testing.AllocsPerRun(100, func() { bf.AddString(web2[0]) }); allocs != 0 {
+			t.Errorf("%s: expected AddString not to allocate, got %v allocations", name, allocs)
+		}
+
+		if allocs := testing.AllocsPerRun(100, func() { bf.CheckString(web2a[0]) }); allocs != 0 {
+			t.Errorf("%s: expected CheckString not to allocate, got %v allocations", name, allocs)
+		}
+	}
+}
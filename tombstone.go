@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+// TombstoneFilter supports approximate deletes by pairing a filter of added
+// items with a filter of deleted ones.  An item is present if it was added
+// and not deleted.
+//
+// Since the deleted filter has false positives of its own, Check may report
+// an item that was never deleted as absent, i.e. a false negative, with a
+// probability close to the deleted filter's error rate.  Once deleted, an
+// item cannot be added back until the filter is compacted.
+type TombstoneFilter struct {
+	main    *Filter
+	deleted *Filter
+}
+
+// NewTombstone initializes a new tombstone filter.
+// n is the number of items each of its two filters is predicted to hold.
+func NewTombstone(n uint, opt ...Option) *TombstoneFilter {
+	return &TombstoneFilter{
+		main:    New(n, opt...),
+		deleted: New(n, opt...),
+	}
+}
+
+func (tf *TombstoneFilter) Add(item []byte) {
+	tf.main.Add(item)
+}
+
+func (tf *TombstoneFilter) Check(item []byte) bool {
+	return tf.main.Check(item) && !tf.deleted.Check(item)
+}
+
+// Delete marks item as deleted.
+func (tf *TombstoneFilter) Delete(item []byte) {
+	tf.deleted.Add(item)
+}
+
+// Compact rebuilds the filter from live, the items currently present,
+// discarding all tombstones.
+func (tf *TombstoneFilter) Compact(live [][]byte) {
+	tf.main.Reset()
+	tf.deleted.Reset()
+
+	for _, item := range live {
+		tf.main.Add(item)
+	}
+}
+
+func (tf *TombstoneFilter) Reset() {
+	tf.main.Reset()
+	tf.deleted.Reset()
+}
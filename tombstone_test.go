@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import "testing"
+
+func TestTombstoneFilter(t *testing.T) {
+	t.Parallel()
+
+	tf := NewTombstone(10000)
+	for _, w := range web2[:10000] {
+		tf.Add([]byte(w))
+	}
+
+	for _, w := range web2[:5000] {
+		tf.Delete([]byte(w))
+	}
+
+	for _, w := range web2[:5000] {
+		if tf.Check([]byte(w)) {
+			t.Fatalf("expected deleted item %q to be absent", w)
+		}
+	}
+
+	fn := 0
+	for _, w := range web2[5000:10000] {
+		if !tf.Check([]byte(w)) {
+			fn++
+		}
+	}
+
+	if fn > 50 {
+		t.Errorf("expected few false negatives, got %d", fn)
+	}
+
+	// Deleted items stay deleted until compaction.
+	tf.Add([]byte(web2[0]))
+	if tf.Check([]byte(web2[0])) {
+		t.Fatal("expected a re-added deleted item to stay absent before Compact")
+	}
+
+	live := [][]byte{[]byte(web2[0])}
+	for _, w := range web2[5000:10000] {
+		live = append(live, []byte(w))
+	}
+	tf.Compact(live)
+
+	for _, item := range live {
+		if !tf.Check(item) {
+			t.Fatalf("expected live item %q to be present after Compact", item)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// log appends a record of item to the write-ahead log.
+func (f *Filter) log(item []byte) {
+	if f.walErr != nil {
+		return
+	}
+
+	rec := make([]byte, 4+len(item)+4)
+	binary.BigEndian.PutUint32(rec, uint32(len(item)))
+	copy(rec[4:], item)
+	binary.BigEndian.PutUint32(rec[4+len(item):], crc32.ChecksumIEEE(rec[:4+len(item)]))
+
+	_, f.walErr = f.wal.Write(rec)
+}
+
+// WALError returns the first error writing to the write-ahead log set with
+// WithWAL, if any.
+func (f *Filter) WALError() error {
+	return f.walErr
+}
+
+// ReplayWAL adds each item recorded in the write-ahead log read from r to bf,
+// which is typically a fresh filter with the options of the one that wrote
+// the log, so as to reproduce it.  A last record cut short or failing its
+// checksum, as left by an interrupted write, is skipped; any other corrupt
+// record is an error.
+func ReplayWAL(bf *Filter, r io.Reader) error {
+	var buf bytes.Buffer
+
+	for i := 0; ; i++ {
+		buf.Reset()
+
+		// Copy rather than preallocate, so that a corrupt length cannot
+		// cause a huge allocation.  CopyN only returns io.EOF when r ends
+		// early, i.e. on the last record.
+		if _, err := io.CopyN(&buf, r, 4); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		l := int64(binary.BigEndian.Uint32(buf.Bytes()))
+		if _, err := io.CopyN(&buf, r, l+4); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		rec := buf.Bytes()
+		if crc32.ChecksumIEEE(rec[:4+l]) != binary.BigEndian.Uint32(rec[4+l:]) {
+			var b [1]byte
+			if _, err := io.ReadFull(r, b[:]); err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("bloom: write-ahead log record %d: %w", i, ErrInvalidEncoding)
+		}
+
+		bf.Add(rec[4 : 4+l])
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Blocknative Corporation. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReplayWAL(t *testing.T) {
+	t.Parallel()
+
+	var log bytes.Buffer
+
+	bf := New(10000, WithWAL(&log))
+	for _, w := range web2[:10000] {
+		bf.Add([]byte(w))
+	}
+
+	if err := bf.WALError(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := New(10000)
+	if err := ReplayWAL(replayed, bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.Count() != bf.Count() {
+		t.Fatalf("expected count %d, got %d", bf.Count(), replayed.Count())
+	}
+
+	for i := range bf.b {
+		if !replayed.b[i].Equal(bf.b[i]) {
+			t.Fatalf("partition %d differs after replay", i)
+		}
+	}
+
+	// A record cut short or corrupted at the end of the log is skipped.
+	data := log.Bytes()
+	for _, tail := range [][]byte{data[:len(data)-3], append(data[:len(data)-1:len(data)-1], 0)} {
+		torn := New(10000)
+		if err := ReplayWAL(torn, bytes.NewReader(tail)); err != nil {
+			t.Fatal(err)
+		}
+
+		if torn.Count() != bf.Count()-1 {
+			t.Errorf("expected the last record to be skipped, got count %d", torn.Count())
+		}
+	}
+
+	// Corruption anywhere else is an error.
+	corrupt := append([]byte(nil), data...)
+	corrupt[5] ^= 0xff
+	if err := ReplayWAL(New(10000), bytes.NewReader(corrupt)); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestWALAddNewAndClone(t *testing.T) {
+	t.Parallel()
+
+	var log bytes.Buffer
+
+	var added []string
+
+	sbf := NewScalable(1000, WithWAL(&log))
+	for _, w := range web2[:3000] {
+		if sbf.AddNew([]byte(w)) {
+			added = append(added, w)
+		}
+	}
+
+	replayed := New(3000)
+	if err := ReplayWAL(replayed, bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.Count() != sbf.Count() {
+		t.Errorf("expected the log to record %d items added by AddNew, got %d", sbf.Count(), replayed.Count())
+	}
+	for _, w := range added {
+		if !replayed.Check([]byte(w)) {
+			t.Fatalf("expected %q to be replayed", w)
+		}
+	}
+
+	// Adds to clones, including to sub-filters they grow, are not logged.
+	n := log.Len()
+
+	sc := sbf.Clone()
+	for _, w := range web2[3000:10000] {
+		sc.Add([]byte(w))
+	}
+
+	bf := New(1000, WithWAL(&log))
+	bf.Clone().Add([]byte("clone"))
+
+	if log.Len() != n {
+		t.Errorf("expected clones not to write to the log, got %d more bytes", log.Len()-n)
+	}
+}